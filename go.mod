@@ -2,4 +2,17 @@ module github.com/bakks/readup
 
 go 1.19
 
-require github.com/creack/pty v1.1.18 // indirect
+require github.com/creack/pty v1.1.18
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require (
+	github.com/mattn/go-runewidth v0.0.15
+	github.com/yuin/goldmark v1.5.6
+	golang.org/x/term v0.13.0
+)
+
+require (
+	github.com/rivo/uniseg v0.2.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+)
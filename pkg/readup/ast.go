@@ -0,0 +1,156 @@
+package readup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Document and Block expose readup's parse of a file, so tools can find
+// and rewrite the blocks readup manages without a fence parser of their
+// own. Whatever isn't changed through them renders exactly as it was
+// parsed.
+
+// Document is a parsed Markdown document.
+type Document struct {
+	doc *document
+}
+
+// Block is a fenced code block in a Document.
+type Block struct {
+	block *codeBlock
+}
+
+// Parse() parses a Markdown document.
+func Parse(data []byte) *Document {
+	return &Document{doc: parseDocument(data)}
+}
+
+// Blocks() returns every fenced code block in the document, whether
+// readup manages it or not, in order.
+func (d *Document) Blocks() []*Block {
+	var blocks []*Block
+	for _, n := range d.doc.nodes {
+		if n.block != nil {
+			blocks = append(blocks, &Block{block: n.block})
+		}
+	}
+	return blocks
+}
+
+// Render() returns the document's text, with any changes made to its
+// blocks.
+func (d *Document) Render() string {
+	return d.doc.render()
+}
+
+// Line() returns the line number of the block's opening fence.
+func (b *Block) Line() int {
+	return b.block.line
+}
+
+// EndLine() returns the line number of the block's closing fence, or of
+// its last line if it runs to the end of the file.
+func (b *Block) EndLine() int {
+	if b.block.closing == "" {
+		return b.block.lastLine() - 1
+	}
+	return b.block.lastLine()
+}
+
+// Lang() returns the language on the block's fence, if any.
+func (b *Block) Lang() string {
+	return b.block.lang
+}
+
+// Attrs() returns the key=value attributes on the block's fence.
+func (b *Block) Attrs() map[string]string {
+	attrs := map[string]string{}
+	for key, value := range b.block.attrs {
+		attrs[key] = value
+	}
+	return attrs
+}
+
+// Managed() reports whether readup runs the block: it's a '> [command]'
+// block or a script.
+func (b *Block) Managed() bool {
+	return b.block.isCommand() || b.IsScript()
+}
+
+// IsScript() reports whether the block is a script, whose output goes in
+// the readup=output block after it.
+func (b *Block) IsScript() bool {
+	return b.block.attrs["readup"] == "script"
+}
+
+// Command() returns the command a managed block runs, or a script
+// block's script, or "" if readup doesn't manage the block.
+func (b *Block) Command() string {
+	if !b.Managed() {
+		return ""
+	}
+	return blockSource(b.block)
+}
+
+// Content() returns the lines between the block's fences, without the
+// prefix of any blockquote or list the block is in.
+func (b *Block) Content() []string {
+	return b.block.content()
+}
+
+// Output() returns the output below a command block's command, or its
+// whole content if it isn't a command block.
+func (b *Block) Output() string {
+	if !b.block.isCommand() {
+		return strings.Join(b.block.content(), "\n")
+	}
+	lines := b.block.commandLines()
+	_, n, err := parseCommand(lines)
+	if err != nil {
+		return ""
+	}
+	return strings.Join(lines[n:], "\n")
+}
+
+// SetOutput() replaces the output below a command block's command, or
+// the whole content of any other block, such as a script's output block.
+func (b *Block) SetOutput(output string) error {
+	if !b.block.isCommand() {
+		if b.IsScript() {
+			return fmt.Errorf("the script on line %d keeps its output in the block after it", b.block.line)
+		}
+		b.block.setOutput(0, output)
+		return nil
+	}
+
+	lines := b.block.commandLines()
+	_, headerLines, err := parseCommand(lines)
+	if err != nil {
+		return err
+	}
+	n, err := b.block.setCommand(lines[:headerLines], headerLines, b.block.attrs.string("echo", "prompt"))
+	if err != nil {
+		return err
+	}
+	b.block.setOutput(n, output)
+	return nil
+}
+
+// SetAttr() sets an attribute on the block's fence, replacing its value
+// if it's already there and adding it at the end if not.
+func (b *Block) SetAttr(key, value string) {
+	c := b.block
+	words := strings.Fields(c.fence[len(c.prefix):])
+	found := false
+	for i, word := range words {
+		if strings.HasPrefix(word, key+"=") {
+			words[i] = key + "=" + value
+			found = true
+		}
+	}
+	if !found {
+		words = append(words, key+"="+value)
+	}
+	c.fence = c.prefix + strings.Join(words, " ")
+	c.attrs[key] = value
+}
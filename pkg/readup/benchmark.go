@@ -0,0 +1,63 @@
+package readup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A block with a benchmark=N attribute runs its command N times, and its
+// output is a summary of how long the runs took rather than what the
+// command printed.
+
+// runBenchmark() runs command runs times and returns the timing summary
+// and the total time taken.
+func runBenchmark(command string, runs int, eo execOptions) (string, time.Duration, error) {
+	print := eo.print
+	if print {
+		fmt.Printf("%s %s (%d runs)\n", paint(colors.label, "Benchmarking:"), paint(colors.command, command), runs)
+	}
+	eo.print = false
+
+	var total, fastest, slowest time.Duration
+	for i := 0; i < runs; i++ {
+		start := time.Now()
+		_, exitCode, err := execCommand(command, eo)
+		if err != nil {
+			return "", 0, err
+		}
+		elapsed := time.Since(start)
+		if exitCode != 0 {
+			return "", 0, fmt.Errorf("command %q exited with status %d on run %d", command, exitCode, i+1)
+		}
+
+		total += elapsed
+		if i == 0 || elapsed < fastest {
+			fastest = elapsed
+		}
+		if elapsed > slowest {
+			slowest = elapsed
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "runs: %d\n", runs)
+	fmt.Fprintf(&b, "min:  %s\n", roundDuration(fastest))
+	fmt.Fprintf(&b, "avg:  %s\n", roundDuration(total/time.Duration(runs)))
+	fmt.Fprintf(&b, "max:  %s\n", roundDuration(slowest))
+
+	if print {
+		fmt.Printf("%s\n%s", paint(colors.label, "Output:"), highlightOutput(b.String(), eo.highlight))
+	}
+	return b.String(), total, nil
+}
+
+// roundDuration() rounds d to three or four significant figures, so
+// a summary doesn't claim nanosecond precision for a multi-second run.
+func roundDuration(d time.Duration) time.Duration {
+	unit := time.Duration(1)
+	for d/unit >= 1000 {
+		unit *= 10
+	}
+	return d.Round(unit)
+}
@@ -0,0 +1,179 @@
+package readup
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// blockResult describes what happened to one command block during a run.
+type blockResult struct {
+	command string
+	// line is the line number of the block's opening fence
+	line int
+	// previous is the output the block held before the run, output what
+	// it holds after
+	previous string
+	output   string
+	// tools are the versions found for the block's tool-version
+	// attribute, e.g. "mycli 1.4.2"
+	tools []string
+	// exitCode is the command's exit status
+	exitCode int
+	// duration is how long the command took to run
+	duration time.Duration
+	// cached is set if the output came from a store rather than
+	// running the command
+	cached bool
+}
+
+// stale() reports whether the block's output changed.
+func (r *blockResult) stale() bool {
+	return r.previous != r.output
+}
+
+// stampPattern matches the comment written after a block by --stamp.
+var stampPattern = regexp.MustCompile(`^<!-- readup: .* -->$`)
+
+// blockStamp() returns a comment recording when a block was refreshed,
+// by which version of readup and with which tool versions.
+func blockStamp(now time.Time, tools []string) string {
+	v, _, _ := buildInfo()
+	stamp := fmt.Sprintf("refreshed %s by readup %s", now.UTC().Format(time.RFC3339), v)
+	if len(tools) > 0 {
+		stamp += " with " + strings.Join(tools, ", ")
+	}
+	return "<!-- readup: " + stamp + " -->"
+}
+
+// heredocPattern matches a heredoc redirection like <<EOF, <<-EOF or
+// <<'EOF', but not a <<< here-string.
+var heredocPattern = regexp.MustCompile(`(?:^|[^<])<<(-?)[ \t]*(?:'([^']*)'|"([^"]*)"|\\?([A-Za-z_][A-Za-z0-9_]*))`)
+
+// parseCommand() reads the command from the lines of a block between
+// its fences. The command follows "> " on the first line, and continues
+// onto the next line for as long as a line ends with a backslash. Like
+// the shell, the backslash and newline are removed when joining lines.
+//
+// If the command uses heredocs (`mycli apply <<EOF`), the lines up to
+// each terminator are part of the command too, and are passed through
+// to the shell which feeds them to the command's stdin.
+//
+// It returns the command and how many lines it took up, which are kept
+// as they are when the block is rewritten.
+func parseCommand(body []string) (string, int, error) {
+	command := strings.TrimPrefix(body[0], "> ")
+	n := 1
+	for strings.HasSuffix(command, "\\") && n < len(body) {
+		command = command[:len(command)-1] + body[n]
+		n++
+	}
+
+	for _, match := range heredocPattern.FindAllStringSubmatch(command, -1) {
+		stripTabs := match[1] == "-"
+		delimiter := match[2] + match[3] + match[4]
+
+		for {
+			if n == len(body) {
+				return "", 0, fmt.Errorf("heredoc <<%s in command %q isn't terminated", delimiter, strings.TrimPrefix(body[0], "> "))
+			}
+			line := body[n]
+			command += "\n" + line
+			n++
+
+			if stripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == delimiter {
+				break
+			}
+		}
+	}
+	return command, n, nil
+}
+
+// blockAttrs are the key=value attributes given on a code block's
+// opening fence, e.g. "```console normalize=version,hostname".
+type blockAttrs map[string]string
+
+// blockAttrNames are the attributes readup understands, which readup
+// lint checks blocks against.
+var blockAttrNames = []string{
+	"benchmark", "capture", "columns", "compare", "container",
+	"container-engine", "controlling-terminal", "echo", "expand-tabs",
+	"expect-fail", "highlight", "id", "ignore-ansi", "ignore-whitespace",
+	"lines", "namespace", "network", "normalize", "pod", "pod-container",
+	"readup", "session", "shell", "show-exit-status", "show-time", "term",
+	"tolerance", "tool-version", "tools", "trim-blank-lines",
+	"trim-trailing-space", "tty", "umask", "venv", "wrap", "wrapper", "wsl",
+}
+
+// parseFence() splits the info string after a code block's opening
+// fence into the language (the first word without an '=') and the
+// block's attributes.
+func parseFence(line string) (string, blockAttrs) {
+	info := strings.TrimLeft(strings.TrimSpace(line), "`~")
+
+	lang := ""
+	attrs := blockAttrs{}
+	for _, word := range strings.Fields(info) {
+		key, value, isAttr := strings.Cut(word, "=")
+		if !isAttr {
+			if lang == "" {
+				lang = word
+			}
+			continue
+		}
+		attrs[key] = value
+	}
+	return lang, attrs
+}
+
+// list() returns the comma-separated values of the attribute key.
+func (a blockAttrs) list(key string) []string {
+	return splitList(a[key])
+}
+
+// bool() reports whether the attribute key is set to a true value, or
+// returns def if it's not set.
+func (a blockAttrs) bool(key string, def bool) bool {
+	value, ok := a[key]
+	if !ok {
+		return def
+	}
+	b, _ := strconv.ParseBool(value)
+	return b
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// int() returns the attribute key as a positive integer, or def if it's
+// not set.
+func (a blockAttrs) int(key string, def int) (int, error) {
+	value, ok := a[key]
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s=%s must be a positive integer", key, value)
+	}
+	return n, nil
+}
+
+// string() returns the attribute key, or def if it's not set.
+func (a blockAttrs) string(key, def string) string {
+	if value, ok := a[key]; ok {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,330 @@
+package readup
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// readup's subcommands all take the same flags and a README file, or a
+// patch for apply. Running `readup [file]` without one is the same as
+// `readup run [file]`.
+
+// subcommand is a word accepted before readup's flags.
+type subcommand struct {
+	name  string
+	usage string
+}
+
+var subcommands = []subcommand{
+	{"run", "run the blocks and offer to update the file (the default)"},
+	{"check", "exit with status 1 if any block's output is out of date"},
+	{"diff", "show how running the blocks would change the file, without updating it"},
+	{"list", "list the blocks that would be run, without running them"},
+	{"test", "run the blocks and report each as ok or failing if its output is out of date"},
+	{"record", "run the blocks and save their output to the store"},
+	{"replay", "update the file from the store rather than running the blocks"},
+	{"apply", "apply a patch made with --patch or --diff-output to the version of the file it was made from"},
+	{"lint", "check the blocks for unknown attributes, duplicate ids, unclosed fences and missing files"},
+	{"fmt", "tidy the fences and prompts of the blocks readup manages, without running them"},
+	{"lsp", "run a language server for editors on stdin and stdout, reporting stale blocks and refreshing them"},
+	{"serve", "serve a local HTTP API for listing and running blocks, and showing and applying their changes"},
+	{"preview", "serve the file rendered as GitHub Flavored Markdown, with its blocks refreshed on demand, reloading as it changes"},
+	{"init", "write a starter config, and optionally an example block and a pre-commit hook"},
+	{"version", "print readup's version, commit, build date and Go version"},
+	{"self-update", "replace readup with the latest release from GitHub"},
+	{"completion", "print a completion script for bash, zsh or fish"},
+}
+
+func subcommandNames() []string {
+	var names []string
+	for _, sc := range subcommands {
+		names = append(names, sc.name)
+	}
+	return names
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: readup [command] [flags] [file]\n\nCommands:\n")
+	for _, sc := range subcommands {
+		fmt.Fprintf(out, "  %-14s%s\n", sc.name, sc.usage)
+	}
+	fmt.Fprintf(out, "\nThe file defaults to ./README.md.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// listBlocks() prints the location, id and command of each block the
+// run would refresh.
+func listBlocks(filename string, opts *options) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range parseDocument(data).nodes {
+		if !shouldRun(n.block, opts) {
+			continue
+		}
+		id := n.block.attrs["id"]
+		if id == "" {
+			id = "-"
+		}
+		source, _, _ := strings.Cut(blockSource(n.block), "\n")
+		fmt.Printf("%s:%d\t%s\t%s\n", filename, n.block.line, id, source)
+	}
+	return nil
+}
+
+// pickBlocks() lists the blocks the run would refresh and asks which to
+// run, returning the lines of the chosen blocks' fences. On a terminal
+// the blocks are a checklist, otherwise they're numbered and chosen by
+// number.
+func pickBlocks(filename string, opts *options) (map[int]bool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []int
+	var items []string
+	for _, n := range parseDocument(data).nodes {
+		if !shouldRun(n.block, opts) {
+			continue
+		}
+		lines = append(lines, n.block.line)
+		source, _, _ := strings.Cut(blockSource(n.block), "\n")
+		label := fmt.Sprintf("line %d", n.block.line)
+		if id := n.block.attrs["id"]; id != "" {
+			label += ", " + id
+		}
+		items = append(items, fmt.Sprintf("%s (%s)", source, label))
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%s has no blocks to run", filename)
+	}
+
+	var chosen []int
+	if canShowChecklist(len(items)) {
+		chosen, err = checklist(items)
+	} else {
+		chosen, err = numberedSelection(items)
+	}
+	if err != nil {
+		return nil, err
+	}
+	picked := map[int]bool{}
+	for _, i := range chosen {
+		picked[lines[i-1]] = true
+	}
+	return picked, nil
+}
+
+// canShowChecklist() reports whether a checklist of n items can be
+// shown, which needs stdin and stdout to be a terminal tall enough to
+// redraw it in place, if its height is known.
+func canShowChecklist(n int) bool {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return false
+	}
+	_, height, err := term.GetSize(int(os.Stdout.Fd()))
+	return err != nil || height == 0 || n < height
+}
+
+// checklist() shows items with a checkbox each, all checked, and lets
+// the user move with the arrow keys or j and k and check or uncheck them
+// with space, or all of them with a. It returns the numbers of the items
+// checked, from 1, when enter is pressed.
+func checklist(items []string) ([]int, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer term.Restore(fd, state)
+
+	checked := make([]bool, len(items))
+	for i := range checked {
+		checked[i] = true
+	}
+	cursor := 0
+	// in raw mode a newline doesn't return the cursor to the start of
+	// the line
+	fmt.Printf("%s\r\n", paint(colors.prompt, "Blocks to run (space checks, a checks all, enter runs, q quits):"))
+	for {
+		for i, item := range items {
+			pointer, box := " ", "[ ]"
+			if i == cursor {
+				pointer = ">"
+			}
+			if checked[i] {
+				box = "[x]"
+			}
+			fmt.Printf("\x1b[K%s %s %s\r\n", pointer, box, item)
+		}
+
+		key, err := readKey()
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "up", "k":
+			cursor = (cursor + len(items) - 1) % len(items)
+		case "down", "j":
+			cursor = (cursor + 1) % len(items)
+		case " ":
+			checked[cursor] = !checked[cursor]
+		case "a":
+			all := true
+			for _, c := range checked {
+				all = all && c
+			}
+			for i := range checked {
+				checked[i] = !all
+			}
+		case "\r", "\n":
+			var chosen []int
+			for i, c := range checked {
+				if c {
+					chosen = append(chosen, i+1)
+				}
+			}
+			if len(chosen) > 0 {
+				return chosen, nil
+			}
+		case "q", "\x03", "\x04":
+			return nil, fmt.Errorf("no blocks chosen")
+		}
+		// back up to redraw the list over itself
+		fmt.Printf("\x1b[%dA", len(items))
+	}
+}
+
+// readKey() reads a key press from stdin in raw mode, returning "up" or
+// "down" for those arrow keys, "" for other keys sent as escape
+// sequences, and the character typed for the rest.
+func readKey() (string, error) {
+	b, err := stdin.ReadByte()
+	if err != nil || b != '\x1b' {
+		return string(b), err
+	}
+	if b, err = stdin.ReadByte(); err != nil || b != '[' && b != 'O' {
+		return "", err
+	}
+	b, err = stdin.ReadByte()
+	switch b {
+	case 'A':
+		return "up", err
+	case 'B':
+		return "down", err
+	}
+	return "", err
+}
+
+// numberedSelection() lists items numbered and asks which to choose,
+// returning the numbers chosen.
+func numberedSelection(items []string) ([]int, error) {
+	for i, item := range items {
+		fmt.Printf("  %3d) %s\n", i+1, item)
+	}
+	for {
+		fmt.Printf("%s ", paint(colors.prompt, "Blocks to run, e.g. 1,3-5 [all]:"))
+		text, err := stdin.ReadString('\n')
+		if err != nil && text == "" {
+			return nil, fmt.Errorf("no blocks chosen")
+		}
+		chosen, err := parseSelection(strings.TrimSpace(text), len(items))
+		if err != nil {
+			fmt.Println(err.Error())
+			continue
+		}
+		return chosen, nil
+	}
+}
+
+// parseSelection() parses a comma-separated list of numbers and ranges
+// like 1,3-5 from 1 to n, where "" or "all" chooses them all.
+func parseSelection(text string, n int) ([]int, error) {
+	var chosen []int
+	if text == "" || text == "all" {
+		for i := 1; i <= n; i++ {
+			chosen = append(chosen, i)
+		}
+		return chosen, nil
+	}
+
+	for _, item := range splitList(text) {
+		from, to, isRange := strings.Cut(item, "-")
+		if !isRange {
+			to = from
+		}
+		first, err1 := strconv.Atoi(strings.TrimSpace(from))
+		last, err2 := strconv.Atoi(strings.TrimSpace(to))
+		if err1 != nil || err2 != nil || first < 1 || last > n || first > last {
+			return nil, fmt.Errorf("%q isn't a block number or range from 1 to %d", item, n)
+		}
+		for i := first; i <= last; i++ {
+			chosen = append(chosen, i)
+		}
+	}
+	return chosen, nil
+}
+
+// diffStyles are the ways changes can be shown, see config.DiffStyle.
+var diffStyles = []string{"file", "block", "both"}
+
+// printDiff() shows the changes to filename, whose content was original,
+// in style. "file" prints diff, the diff of the whole file. "block"
+// prints, for each stale block in results, its command and then a diff
+// of its output alone. "both" prints the file's diff followed by the
+// blocks'.
+func printDiff(style, filename, original, diff string, results []*blockResult) error {
+	if style != "block" {
+		fmt.Println(diffFormat(diff, proseLines(original)))
+	}
+	if style == "file" {
+		return nil
+	}
+	for _, result := range results {
+		if !result.stale() {
+			continue
+		}
+		hunks, err := blockDiff(result)
+		if err != nil {
+			return err
+		}
+		command, _, _ := strings.Cut(result.command, "\n")
+		fmt.Println(paint(colors.heading, fmt.Sprintf("%s:%d: %s", filename, result.line, command)))
+		fmt.Println(diffFormat(hunks, nil))
+	}
+	return nil
+}
+
+// printLintProblems() prints problems found in filename, one per line,
+// and returns how many there were.
+func printLintProblems(filename string, problems []lintProblem) int {
+	for _, problem := range problems {
+		fmt.Printf("%s:%d: %s\n", filename, problem.line, problem.message)
+	}
+	return len(problems)
+}
+
+// printTestResults() reports each block as ok, or failing if its output
+// is out of date, and returns how many failed.
+func printTestResults(filename string, results []*blockResult) int {
+	failed := 0
+	for _, result := range results {
+		status := paint(colors.success, "ok  ")
+		if result.stale() {
+			status = paint(colors.failure, "FAIL")
+			failed++
+		}
+		command, _, _ := strings.Cut(result.command, "\n")
+		fmt.Printf("%s %s:%d %s\n", status, filename, result.line, command)
+	}
+	return failed
+}
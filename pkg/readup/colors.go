@@ -0,0 +1,169 @@
+package readup
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Everything readup styles in the terminal takes its style from one
+// palette, by the role of the text rather than a hardcoded escape code,
+// so diffs, echoed commands, prompts and summaries stay consistent and
+// anything new, like an interactive mode, can style itself the same way.
+// The config's colors section chooses a preset and replaces any of its
+// roles' styles:
+//
+//	colors:
+//	  preset: colorblind
+//	  output: dim-white
+//	  command: bold
+//
+// The presets are "default" (red and green, with output in grey),
+// "colorblind" (orange and blue) and "none". A style is a color name
+// like red, bright-red or dim-red, bold or italic, the parameters of an
+// SGR escape sequence like "38;5;208", or "none".
+
+// palette is the SGR parameters of each role, or "" to leave it plain.
+type palette struct {
+	// removed and added are the lines of a diff
+	removed string
+	added   string
+	// output is a command's echoed output
+	output string
+	// label is a label like "Running:", command a command being run,
+	// and heading what introduces a section, like a block's diff
+	label   string
+	command string
+	heading string
+	// prompt is a question for the user
+	prompt string
+	// success and failure are outcomes, like "ok" and "FAIL"
+	success string
+	failure string
+	// emphasis and strong style emphasized Markdown text, see prose.go
+	emphasis string
+	strong   string
+	// key, string, number and literal highlight echoed output, see
+	// highlight.go
+	key     string
+	string  string
+	number  string
+	literal string
+}
+
+var palettes = map[string]palette{
+	"default": {
+		removed: "31", added: "32", output: "90", heading: "1",
+		emphasis: "3", strong: "1",
+		key: "36", string: "32", number: "33", literal: "35",
+	},
+	"colorblind": {
+		removed: "38;5;208", added: "38;5;33", output: "90", heading: "1",
+		emphasis: "3", strong: "1",
+		key: "38;5;33", string: "38;5;208", number: "38;5;229", literal: "38;5;183",
+	},
+	"none": {},
+}
+
+// colors is the palette terminal output is styled with.
+var colors = palettes["default"]
+
+// colorsConfig is the colors section of the config.
+type colorsConfig struct {
+	Preset   string `yaml:"preset"`
+	Removed  string `yaml:"removed"`
+	Added    string `yaml:"added"`
+	Output   string `yaml:"output"`
+	Label    string `yaml:"label"`
+	Command  string `yaml:"command"`
+	Heading  string `yaml:"heading"`
+	Prompt   string `yaml:"prompt"`
+	Success  string `yaml:"success"`
+	Failure  string `yaml:"failure"`
+	Emphasis string `yaml:"emphasis"`
+	Strong   string `yaml:"strong"`
+	Key      string `yaml:"key"`
+	String   string `yaml:"string"`
+	Number   string `yaml:"number"`
+	Literal  string `yaml:"literal"`
+}
+
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+var sgrPattern = regexp.MustCompile(`^[0-9]+(;[0-9]+)*$`)
+
+// palette() returns the palette the config describes.
+func (c colorsConfig) palette() (palette, error) {
+	preset := firstString(c.Preset, "default")
+	p, ok := palettes[preset]
+	if !ok {
+		return palette{}, fmt.Errorf("unknown colors preset %q, must be default, colorblind or none", preset)
+	}
+	for _, color := range []struct {
+		name  string
+		value string
+		sgr   *string
+	}{
+		{"removed", c.Removed, &p.removed},
+		{"added", c.Added, &p.added},
+		{"output", c.Output, &p.output},
+		{"label", c.Label, &p.label},
+		{"command", c.Command, &p.command},
+		{"heading", c.Heading, &p.heading},
+		{"prompt", c.Prompt, &p.prompt},
+		{"success", c.Success, &p.success},
+		{"failure", c.Failure, &p.failure},
+		{"emphasis", c.Emphasis, &p.emphasis},
+		{"strong", c.Strong, &p.strong},
+		{"key", c.Key, &p.key},
+		{"string", c.String, &p.string},
+		{"number", c.Number, &p.number},
+		{"literal", c.Literal, &p.literal},
+	} {
+		if color.value == "" {
+			continue
+		}
+		sgr, err := parseColor(color.value)
+		if err != nil {
+			return palette{}, fmt.Errorf("colors.%s: %w", color.name, err)
+		}
+		*color.sgr = sgr
+	}
+	return p, nil
+}
+
+// parseColor() returns the SGR parameters for a color.
+func parseColor(color string) (string, error) {
+	switch color {
+	case "none":
+		return "", nil
+	case "bold":
+		return "1", nil
+	case "italic":
+		return "3", nil
+	}
+	if sgrPattern.MatchString(color) {
+		return color, nil
+	}
+
+	name, base, prefix := color, 30, ""
+	if strings.HasPrefix(color, "bright-") {
+		name, base = strings.TrimPrefix(color, "bright-"), 90
+	} else if strings.HasPrefix(color, "dim-") {
+		name, prefix = strings.TrimPrefix(color, "dim-"), "2;"
+	}
+	for i, known := range colorNames {
+		if name == known {
+			return fmt.Sprintf("%s%d", prefix, base+i), nil
+		}
+	}
+	return "", fmt.Errorf("unknown color %q", color)
+}
+
+// paint() returns s in the color sgr, or as it is if sgr is "".
+func paint(sgr, s string) string {
+	if sgr == "" {
+		return s
+	}
+	return "\x1b[" + sgr + "m" + s + "\x1b[0m"
+}
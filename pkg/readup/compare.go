@@ -0,0 +1,188 @@
+package readup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A block is stale when its new output differs from what the document
+// holds, but some differences aren't worth a failed check or a rewrite.
+// With --ignore-whitespace (or ignore_whitespace: true in the config, or
+// a block's ignore-whitespace attribute), output that differs only in
+// trailing whitespace or blank lines counts as unchanged, and the block
+// keeps the output it had. Likewise with --ignore-ansi (ignore_ansi, or
+// the ignore-ansi attribute), output is compared without its escape
+// sequences, so a block keeping colored output isn't rewritten because a
+// new version of a tool resets a color differently.
+//
+// A block can also choose how its output is compared with the compare
+// attribute, or the config's compare setting for every block:
+//
+//   - exact, the default, compares the output as it is
+//   - normalized applies every normalizer to both outputs and ignores
+//     whitespace, so a timestamp or UUID the document holds verbatim
+//     doesn't count as a change
+//   - json-equal compares the output as JSON values, so the order of an
+//     object's keys and how numbers are written don't matter
+//   - numeric-tolerance lets numbers differ by the block's tolerance
+//     attribute, a difference like 0.01 or a percentage like 5% (the
+//     default is 1%), while the rest of the output matches exactly
+//
+// Output that compares equal is left as the document has it, so a run
+// doesn't rewrite the block either.
+
+// comparators report whether a block's previous and new output are the
+// same as far as the block is concerned.
+var comparators = map[string]func(previous, output string, attrs blockAttrs, opts *options) (bool, error){
+	"exact": func(previous, output string, _ blockAttrs, _ *options) (bool, error) {
+		return previous == output, nil
+	},
+	"normalized": func(previous, output string, _ blockAttrs, opts *options) (bool, error) {
+		return whitespaceEqual(normalize(previous, opts.normalizers), normalize(output, opts.normalizers)), nil
+	},
+	"json-equal": func(previous, output string, _ blockAttrs, _ *options) (bool, error) {
+		return jsonEqual(previous, output), nil
+	},
+	"numeric-tolerance": func(previous, output string, attrs blockAttrs, _ *options) (bool, error) {
+		tolerance, relative, err := parseTolerance(attrs.string("tolerance", defaultTolerance))
+		if err != nil {
+			return false, err
+		}
+		return numericEqual(previous, output, tolerance, relative), nil
+	},
+}
+
+// comparatorNames are the names of the comparators, for messages.
+var comparatorNames = []string{"exact", "normalized", "json-equal", "numeric-tolerance"}
+
+const defaultTolerance = "1%"
+
+// keepUnchanged() puts result's previous output back if the new output
+// only differs from it in ways the block's attributes say to ignore, so
+// the block isn't stale and isn't rewritten.
+func keepUnchanged(result *blockResult, attrs blockAttrs, opts *options) error {
+	if result.previous == result.output {
+		return nil
+	}
+	name := attrs.string("compare", opts.compare)
+	compare, ok := comparators[name]
+	if !ok {
+		return fmt.Errorf("unknown comparator %q (available: %s)", name, strings.Join(comparatorNames, ", "))
+	}
+
+	previous, output := result.previous, result.output
+	if attrs.bool("ignore-ansi", opts.ignoreANSI) {
+		previous, output = stripEscapes(previous), stripEscapes(output)
+	}
+	equal, err := compare(previous, output, attrs, opts)
+	if err != nil {
+		return err
+	}
+	if equal || attrs.bool("ignore-whitespace", opts.ignoreWhitespace) && whitespaceEqual(previous, output) {
+		result.output = result.previous
+	}
+	return nil
+}
+
+// whitespaceEqual() reports whether a and b have the same lines, ignoring
+// trailing whitespace and blank lines.
+func whitespaceEqual(a, b string) bool {
+	return reflect.DeepEqual(significantLines(a), significantLines(b))
+}
+
+// significantLines() returns the lines of s that aren't blank, without
+// trailing whitespace.
+func significantLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// jsonEqual() reports whether a and b hold the same sequence of JSON
+// values. Output that isn't JSON is never equal.
+func jsonEqual(a, b string) bool {
+	x, err := jsonValues(a)
+	if err != nil {
+		return false
+	}
+	y, err := jsonValues(b)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// jsonValues() decodes the JSON values in s, which may be several, like
+// the lines of JSON Lines output.
+func jsonValues(s string) ([]interface{}, error) {
+	var values []interface{}
+	decoder := json.NewDecoder(strings.NewReader(s))
+	for {
+		var value interface{}
+		err := decoder.Decode(&value)
+		if errors.Is(err, io.EOF) {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+}
+
+// numberPattern matches the numbers numeric-tolerance compares.
+var numberPattern = regexp.MustCompile(`-?\d+(?:\.\d+)?(?:[eE][+-]?\d+)?`)
+
+// parseTolerance() parses a tolerance attribute, returning the allowed
+// difference and whether it's relative to the number's size.
+func parseTolerance(s string) (float64, bool, error) {
+	relative := strings.HasSuffix(s, "%")
+	tolerance, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || tolerance < 0 {
+		return 0, false, fmt.Errorf("tolerance=%s must be a number like 0.01 or a percentage like 5%%", s)
+	}
+	if relative {
+		tolerance /= 100
+	}
+	return tolerance, relative, nil
+}
+
+// numericEqual() reports whether a and b are the same apart from numbers
+// differing by at most tolerance, or tolerance times the larger of the
+// two if relative is set.
+func numericEqual(a, b string, tolerance float64, relative bool) bool {
+	x, y := numberPattern.FindAllStringIndex(a, -1), numberPattern.FindAllStringIndex(b, -1)
+	if len(x) != len(y) {
+		return false
+	}
+
+	i, j := 0, 0
+	for n := range x {
+		if a[i:x[n][0]] != b[j:y[n][0]] {
+			return false
+		}
+		m, _ := strconv.ParseFloat(a[x[n][0]:x[n][1]], 64)
+		p, _ := strconv.ParseFloat(b[y[n][0]:y[n][1]], 64)
+		limit := tolerance
+		if relative {
+			limit *= math.Max(math.Abs(m), math.Abs(p))
+		}
+		if math.Abs(m-p) > limit {
+			return false
+		}
+		i, j = x[n][1], y[n][1]
+	}
+	return a[i:] == b[j:]
+}
@@ -0,0 +1,82 @@
+package readup
+
+import "testing"
+
+func TestKeepUnchanged(t *testing.T) {
+	tests := []struct {
+		name             string
+		previous, output string
+		attrs            blockAttrs
+		opts             options
+		unchanged        bool
+	}{
+		{"same", "a\n", "a\n", blockAttrs{}, options{}, true},
+		{"exact by default", "a\n", "a \n", blockAttrs{}, options{}, false},
+		{"ignore whitespace", "a\n\nb\n", "a  \nb\n\n", blockAttrs{}, options{ignoreWhitespace: true}, true},
+		{"ignore whitespace attribute", "a\n", "a \n", blockAttrs{"ignore-whitespace": "true"}, options{}, true},
+		{"ignore whitespace keeps other changes", "a\n", "b\n", blockAttrs{}, options{ignoreWhitespace: true}, false},
+		{"ignore ansi", "\x1b[31mred\x1b[0m", "\x1b[31mred\x1b[m", blockAttrs{"ignore-ansi": "true"}, options{}, true},
+		{"ansi counts by default", "\x1b[31mred\x1b[0m", "red", blockAttrs{}, options{}, false},
+		{"normalized", "at 2024-01-02T03:04:05Z\n", "at 2025-06-07T08:09:10Z \n", blockAttrs{"compare": "normalized"},
+			options{normalizers: builtinNormalizers}, true},
+		{"normalized from options", "took 1.5s", "took 2s", blockAttrs{}, options{compare: "normalized", normalizers: builtinNormalizers}, true},
+		{"json equal", `{"a": 1, "b": [1, 2]}`, "{\"b\":[1,2],\n\"a\":1.0}", blockAttrs{"compare": "json-equal"}, options{}, true},
+		{"json lines", "{\"a\":1}\n{\"b\":2}\n", "{\"a\": 1}\n{\"b\": 2}\n", blockAttrs{"compare": "json-equal"}, options{}, true},
+		{"json differs", `{"a": 1}`, `{"a": 2}`, blockAttrs{"compare": "json-equal"}, options{}, false},
+		{"not json", "a", "a ", blockAttrs{"compare": "json-equal"}, options{}, false},
+		{"tolerance default", "took 100.0ms", "took 100.5ms", blockAttrs{"compare": "numeric-tolerance"}, options{}, true},
+		{"tolerance exceeded", "took 100ms", "took 105ms", blockAttrs{"compare": "numeric-tolerance"}, options{}, false},
+		{"tolerance absolute", "0.50 and 3", "0.52 and 3", blockAttrs{"compare": "numeric-tolerance", "tolerance": "0.05"}, options{}, true},
+		{"tolerance text differs", "took 100ms", "took 100s", blockAttrs{"compare": "numeric-tolerance"}, options{}, false},
+		{"tolerance count differs", "1 2", "1 2 3", blockAttrs{"compare": "numeric-tolerance"}, options{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			if opts.compare == "" {
+				opts.compare = "exact"
+			}
+			result := &blockResult{previous: tt.previous, output: tt.output}
+			if err := keepUnchanged(result, tt.attrs, &opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := result.output == tt.previous; got != tt.unchanged {
+				t.Errorf("unchanged = %v, want %v", got, tt.unchanged)
+			}
+			if !tt.unchanged && result.output != tt.output {
+				t.Errorf("output = %q, want the new output %q", result.output, tt.output)
+			}
+		})
+	}
+}
+
+func TestKeepUnchangedErrors(t *testing.T) {
+	for _, attrs := range []blockAttrs{
+		{"compare": "fuzzy"},
+		{"compare": "numeric-tolerance", "tolerance": "lots"},
+		{"compare": "numeric-tolerance", "tolerance": "-1"},
+	} {
+		result := &blockResult{previous: "1", output: "2"}
+		if err := keepUnchanged(result, attrs, &options{compare: "exact"}); err == nil {
+			t.Errorf("keepUnchanged() with %v succeeded", attrs)
+		}
+	}
+}
+
+func TestParseTolerance(t *testing.T) {
+	tests := []struct {
+		in       string
+		want     float64
+		relative bool
+	}{
+		{"0.01", 0.01, false},
+		{"5%", 0.05, true},
+		{"0", 0, false},
+	}
+	for _, tt := range tests {
+		got, relative, err := parseTolerance(tt.in)
+		if err != nil || got != tt.want || relative != tt.relative {
+			t.Errorf("parseTolerance(%q) = %v, %v, %v, want %v, %v", tt.in, got, relative, err, tt.want, tt.relative)
+		}
+	}
+}
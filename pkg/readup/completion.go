@@ -0,0 +1,126 @@
+package readup
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// `readup completion bash|zsh|fish` prints a completion script for the
+// shell, covering readup's subcommands and flags, and the ids of the
+// blocks in the README for --only.
+
+// completionShells are the shells completion scripts can be printed for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// blockIDsCommand is the hidden subcommand the completion scripts run to
+// list block ids.
+const blockIDsCommand = "__block-ids"
+
+// printCompletion() prints the completion script for shell.
+func printCompletion(shell string) error {
+	switch shell {
+	case "bash":
+		fmt.Print(bashCompletion())
+	case "zsh":
+		// zsh can run bash completion functions
+		fmt.Print("autoload -U +X compinit && compinit\nautoload -U +X bashcompinit && bashcompinit\n" + bashCompletion())
+	case "fish":
+		fmt.Print(fishCompletion())
+	default:
+		return fmt.Errorf("can't complete for shell %q (available: %s)", shell, strings.Join(completionShells, ", "))
+	}
+	return nil
+}
+
+func bashCompletion() string {
+	var flags []string
+	flag.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, "--"+f.Name)
+	})
+
+	return fmt.Sprintf(`_readup() {
+    local cur prev file word
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+
+    for word in "${COMP_WORDS[@]:1}"; do
+        case "$word" in
+            *.md) file="$word" ;;
+        esac
+    done
+
+    case "$prev" in
+        -only|--only)
+            COMPREPLY=($(compgen -W "$(readup %s $file 2>/dev/null)" -- "$cur"))
+            return ;;
+        -completion|completion)
+            COMPREPLY=($(compgen -W "%s" -- "$cur"))
+            return ;;
+    esac
+
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+    elif [[ $COMP_CWORD -eq 1 ]]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur") $(compgen -f -X '!*.md' -- "$cur"))
+    else
+        COMPREPLY=($(compgen -f -X '!*.md' -- "$cur"))
+    fi
+}
+complete -o filenames -F _readup readup
+`, blockIDsCommand, strings.Join(completionShells, " "), strings.Join(flags, " "), strings.Join(subcommandNames(), " "))
+}
+
+func fishCompletion() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "complete -c readup -n '__fish_use_subcommand' -a '%s'\n", strings.Join(subcommandNames(), " "))
+	fmt.Fprintf(&b, "complete -c readup -n '__fish_seen_subcommand_from completion' -x -a '%s'\n", strings.Join(completionShells, " "))
+
+	flag.VisitAll(func(f *flag.Flag) {
+		line := fmt.Sprintf("complete -c readup -l %s -d %s", f.Name, fishQuote(firstLine(f.Usage)))
+		if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !bf.IsBoolFlag() {
+			line += " -r"
+		}
+		if f.Name == "only" {
+			line += fmt.Sprintf(" -x -a '(readup %s (commandline -opc | string match \"*.md\")[1] 2>/dev/null)'", blockIDsCommand)
+		}
+		b.WriteString(line + "\n")
+	})
+	return b.String()
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", `\'`) + "'"
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// printBlockIDs() prints the ids of the blocks in the file named in args
+// (./README.md by default), one per line. Errors are ignored since
+// there's nobody to see them while completing.
+func printBlockIDs(args []string) {
+	filename := "./README.md"
+	if len(args) > 0 {
+		filename = args[0]
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+
+	var ids []string
+	for _, n := range parseDocument(data).nodes {
+		if n.block != nil && n.block.attrs["id"] != "" && !contains(ids, n.block.attrs["id"]) {
+			ids = append(ids, n.block.attrs["id"])
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		fmt.Println(id)
+	}
+}
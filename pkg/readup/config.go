@@ -0,0 +1,453 @@
+package readup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile is read from the current directory and each of its
+// parents if no config file is given explicitly, see layers.go.
+const defaultConfigFile = ".readup.yaml"
+
+const (
+	defaultLocale    = "C.UTF-8"
+	defaultTimezone  = "UTC"
+	defaultMaxOutput = "1M"
+)
+
+// defaultDeterministicEnv is used in deterministic mode when the config
+// doesn't set deterministic_env.
+var defaultDeterministicEnv = map[string]string{
+	"SOURCE_DATE_EPOCH": "0",
+}
+
+// config is the contents of a readup config file.
+type config struct {
+	// Normalizers are project-specific output rewrites, keyed by the
+	// name blocks use to opt into them, e.g. `normalize=version`.
+	Normalizers map[string]normalizerConfig `yaml:"normalizers"`
+
+	// Locale and Timezone are pinned for block commands so that output
+	// doesn't depend on who ran readup. Setting either to an empty string
+	// inherits the value from readup's own environment instead.
+	Locale   *string `yaml:"locale"`
+	Timezone *string `yaml:"timezone"`
+
+	// Deterministic enables DeterministicEnv for every block command, for
+	// tools that honor variables like SOURCE_DATE_EPOCH or a random seed.
+	Deterministic    bool              `yaml:"deterministic"`
+	DeterministicEnv map[string]string `yaml:"deterministic_env"`
+
+	// OfflineScope controls which blocks --offline refuses to run:
+	// "network" (the default) for blocks marked network=true, or "all".
+	OfflineScope string `yaml:"offline_scope"`
+
+	// Stamp writes a comment after each block recording when its output
+	// last changed.
+	Stamp bool `yaml:"stamp"`
+
+	// StrictToolVersions fails blocks whose tool-version attribute isn't
+	// satisfied by the installed tool, instead of warning.
+	StrictToolVersions bool `yaml:"strict_tool_versions"`
+
+	// MaxOutput is the most output a block's command may produce before
+	// it's killed, e.g. "10M".
+	MaxOutput string `yaml:"max_output"`
+
+	// Term, Columns and Lines describe the terminal block commands run
+	// in. Blocks can override them with the term, columns and lines
+	// attributes.
+	Term    string `yaml:"term"`
+	Columns int    `yaml:"columns"`
+	Lines   int    `yaml:"lines"`
+
+	// Capture is how block output is captured ("pty", "wide" or "pipe"),
+	// and Wrap the width to soft-wrap output lines at. Blocks can
+	// override these with the capture and wrap attributes.
+	Capture string `yaml:"capture"`
+	Wrap    int    `yaml:"wrap"`
+
+	// ExpandTabs replaces tabs in block output with spaces, with tab
+	// stops this many columns apart. Blocks can override it with the
+	// expand-tabs attribute.
+	ExpandTabs int `yaml:"expand_tabs"`
+
+	// TrimTrailingSpace strips trailing whitespace from output lines and
+	// TrimBlankLines drops blank lines from the end of a block's output.
+	// Blocks can override them with the trim-trailing-space and
+	// trim-blank-lines attributes.
+	TrimTrailingSpace bool `yaml:"trim_trailing_space"`
+	TrimBlankLines    bool `yaml:"trim_blank_lines"`
+
+	// IgnoreWhitespace counts output that only differs from a block's in
+	// trailing whitespace or blank lines as unchanged. Blocks can override
+	// it with the ignore-whitespace attribute.
+	IgnoreWhitespace bool `yaml:"ignore_whitespace"`
+
+	// IgnoreANSI compares output without its escape sequences, so output
+	// that only differs in colors counts as unchanged. Blocks can override
+	// it with the ignore-ansi attribute.
+	IgnoreANSI bool `yaml:"ignore_ansi"`
+
+	// Compare is how a block's new output is compared with what it holds
+	// to decide whether it changed, see compare.go. Blocks can override it
+	// with the compare attribute.
+	Compare string `yaml:"compare"`
+
+	// Interpreters map a fence language to the command that runs script
+	// blocks in that language, which reads the script from stdin, e.g.
+	// `python: python3 -`.
+	Interpreters map[string]string `yaml:"interpreters"`
+
+	// Echo is how a block's command is shown once it's run: "prompt"
+	// (the default) as "> command", "dollar" as "$ command", or "hidden"
+	// to show only the output. Blocks can override it with the echo
+	// attribute.
+	Echo string `yaml:"echo"`
+
+	// DiffStyle is how changes are shown before updating a file: "file"
+	// (the default) as one diff of the whole file, "block" as a diff of
+	// each changed block under its command, or "both".
+	DiffStyle string `yaml:"diff_style"`
+
+	// DiffContext is how many lines of context diffs and patches have
+	// around each change, 3 by default.
+	DiffContext *int `yaml:"diff_context"`
+
+	// Colors are the colors of diffs and echoed output, see colors.go.
+	Colors colorsConfig `yaml:"colors"`
+
+	// OutputIndent is what echoed output is indented with: a number of
+	// spaces (2 by default), "none", or a prefix like "| ".
+	OutputIndent *string `yaml:"output_indent"`
+
+	// Highlight highlights echoed output by its block's language, see
+	// highlight.go.
+	Highlight bool `yaml:"highlight"`
+
+	// CleanEnv starts block commands from a minimal environment rather
+	// than readup's own, so output doesn't depend on whoever runs it.
+	// Only PATH, HOME and the variables named in EnvPassthrough are kept;
+	// a name ending in * keeps every variable starting with the rest.
+	CleanEnv       bool     `yaml:"clean_env"`
+	EnvPassthrough []string `yaml:"env_passthrough"`
+
+	// PathPrepend are directories put at the front of PATH for block
+	// commands, e.g. ./node_modules/.bin, so project-local tools are
+	// found without installing them. They're relative to the config
+	// file.
+	PathPrepend []string `yaml:"path_prepend"`
+
+	// Shell runs block commands with -c, e.g. /bin/zsh for examples
+	// using zsh syntax. Blocks can override it with the shell attribute.
+	Shell string `yaml:"shell"`
+
+	// ContainerEngine is "docker" (the default) or "podman", for blocks
+	// with container=<name> which run in that running container. Blocks
+	// can override it with the container-engine attribute.
+	ContainerEngine string `yaml:"container_engine"`
+
+	// KubeContext and KubeNamespace choose where blocks with pod=<name>
+	// run, instead of kubectl's current context and namespace. Blocks
+	// can override the namespace with the namespace attribute.
+	KubeContext   string `yaml:"kube_context"`
+	KubeNamespace string `yaml:"kube_namespace"`
+
+	// Wrapper runs every block command inside a command that sets up
+	// the project's environment, e.g. "nix" for `nix develop -c`, and
+	// Wrappers name more of them. Blocks can choose another with the
+	// wrapper attribute, or none with wrapper=none.
+	Wrapper  string            `yaml:"wrapper"`
+	Wrappers map[string]string `yaml:"wrappers"`
+
+	// ToolManager is "mise" (the default) or "asdf", which activates the
+	// tool versions blocks ask for with e.g. tools=node@20.
+	ToolManager string `yaml:"tool_manager"`
+
+	// Hooks are commands run before and after each block, and each write
+	// of the file, see hooks.go.
+	Hooks hooksConfig `yaml:"hooks"`
+
+	// Notify sends a desktop notification or calls a webhook when a run
+	// finishes or fails, see notify.go.
+	Notify notifyConfig `yaml:"notify"`
+
+	// Deliver emails or posts the diff and a summary of each run's
+	// changes, see deliver.go.
+	Deliver deliverConfig `yaml:"deliver"`
+
+	// Workspace lists the documents `readup --all` runs, see
+	// workspace.go.
+	Workspace workspaceConfig `yaml:"workspace"`
+
+	// Root stops readup looking for configs in parent directories to
+	// layer this one over.
+	Root bool `yaml:"root"`
+
+	// dir is the directory of the root config file, which the workspace
+	// is relative to, or "" if there's no config file.
+	dir string
+}
+
+type normalizerConfig struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// loadConfig() reads the config file at filename. If filename is empty
+// the default config files for the current directory are used, see
+// loadConfigIn().
+func loadConfig(filename string) (*config, error) {
+	return loadConfigIn(filename, ".")
+}
+
+// loadConfigIn() reads the config file at filename, or if filename is
+// empty, the default config files that apply in dir layered over each
+// other, see layers.go. If there are none an empty config is returned.
+func loadConfigIn(filename, dir string) (*config, error) {
+	files := []string{filename}
+	if filename == "" {
+		var err error
+		files, err = findConfigs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return &config{}, nil
+		}
+	}
+
+	// the nearest config first, back to the root
+	var layers []*yaml.Node
+	for _, name := range files {
+		node, err := readConfigLayer(name)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, node)
+		if filename == "" && isRootConfig(node) {
+			files = files[:len(layers)]
+			break
+		}
+	}
+
+	var merged *yaml.Node
+	for i := len(layers) - 1; i >= 0; i-- {
+		merged = mergeConfigNodes(merged, layers[i])
+	}
+	cfg := &config{}
+	if merged != nil {
+		if err := merged.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", files[0], err)
+		}
+	}
+
+	abs, err := filepath.Abs(files[len(files)-1])
+	if err != nil {
+		return nil, err
+	}
+	cfg.dir = filepath.Dir(abs)
+	return cfg, nil
+}
+
+// findConfigs() returns the default config files in dir and each of its
+// parents, nearest first.
+func findConfigs(dir string) ([]string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for {
+		name := filepath.Join(dir, defaultConfigFile)
+		if _, err := os.Stat(name); err == nil {
+			files = append(files, name)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return files, nil
+		}
+		dir = parent
+	}
+}
+
+// path() returns name, a path from the config, relative to the config
+// file rather than the current directory.
+func (c *config) path(name string) string {
+	if c.dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(c.dir, name)
+}
+
+// normalizers() compiles the config's normalizer rules, sorted by name.
+func (c *config) normalizers() ([]*normalizer, error) {
+	var names []string
+	for name := range c.Normalizers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var normalizers []*normalizer
+	for _, name := range names {
+		rule := c.Normalizers[name]
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("normalizer %q: %w", name, err)
+		}
+
+		normalizers = append(normalizers, &normalizer{
+			name: name,
+			rules: []normalizeRule{
+				{pattern: pattern, replacement: rule.Replacement},
+			},
+		})
+	}
+	return normalizers, nil
+}
+
+// env() returns the environment variables the config adds to every block
+// command, including the deterministic ones if deterministic is set.
+func (c *config) env(deterministic bool) []string {
+	locale := defaultLocale
+	if c.Locale != nil {
+		locale = *c.Locale
+	}
+	timezone := defaultTimezone
+	if c.Timezone != nil {
+		timezone = *c.Timezone
+	}
+
+	var env []string
+	if locale != "" {
+		env = append(env, "LANG="+locale, "LC_ALL="+locale)
+	}
+	if timezone != "" {
+		env = append(env, "TZ="+timezone)
+	}
+
+	if deterministic || c.Deterministic {
+		vars := c.DeterministicEnv
+		if vars == nil {
+			vars = defaultDeterministicEnv
+		}
+
+		var names []string
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			env = append(env, name+"="+vars[name])
+		}
+	}
+	return env
+}
+
+// wrappers() returns the environment wrappers known by name.
+func (c *config) wrappers() map[string]string {
+	wrappers := map[string]string{}
+	for name, command := range defaultWrappers {
+		wrappers[name] = command
+	}
+	for name, command := range c.Wrappers {
+		wrappers[name] = command
+	}
+	return wrappers
+}
+
+// minimalEnv are the variables a clean environment always keeps.
+var minimalEnv = []string{"PATH", "HOME"}
+
+// baseEnv() returns the environment block commands start from, or nil
+// to inherit readup's own.
+func (c *config) baseEnv(clean bool) []string {
+	if !clean && !c.CleanEnv {
+		return nil
+	}
+
+	patterns := append(append([]string{}, minimalEnv...), c.EnvPassthrough...)
+	env := []string{}
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		for _, pattern := range patterns {
+			if name == pattern || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(name, strings.TrimSuffix(pattern, "*"))) {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+	return env
+}
+
+// prependPath() returns a PATH variable with dirs ahead of the current
+// PATH, or "" if there are no dirs. Relative directories are made
+// absolute, so they still work for commands that change directory.
+func prependPath(dirs []string) (string, error) {
+	if len(dirs) == 0 {
+		return "", nil
+	}
+
+	var abs []string
+	for _, dir := range dirs {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return "", err
+		}
+		abs = append(abs, dir)
+	}
+	if path := os.Getenv("PATH"); path != "" {
+		abs = append(abs, path)
+	}
+	return "PATH=" + strings.Join(abs, string(os.PathListSeparator)), nil
+}
+
+// parseSize() parses a byte count with an optional K, M or G suffix.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size", s)
+	}
+	return n * multiplier, nil
+}
+
+// interpreters() returns the default script interpreters with the
+// config's added or replacing them.
+func (c *config) interpreters() map[string]string {
+	interpreters := map[string]string{}
+	for lang, interpreter := range defaultInterpreters {
+		interpreters[lang] = interpreter
+	}
+	for lang, interpreter := range c.Interpreters {
+		interpreters[lang] = interpreter
+	}
+	return interpreters
+}
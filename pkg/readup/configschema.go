@@ -0,0 +1,169 @@
+package readup
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The config is checked against the config struct before it's decoded,
+// since yaml.Unmarshal() quietly ignores fields it doesn't know, so a
+// typo like `colums: 120` would otherwise have no effect at all.
+
+// checkConfig() returns an error describing every unknown field and
+// wrongly typed value in the YAML config data, each with its line and
+// path, or nil if there are none.
+func checkConfig(data []byte) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
+		return nil
+	}
+
+	var problems []string
+	checkNode(root.Content[0], reflect.TypeOf(config{}), "", &problems)
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// checkNode() adds what's wrong with node, which should hold a t at
+// path, to problems.
+func checkNode(node *yaml.Node, t reflect.Type, path string, problems *[]string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Tag == "!!null" {
+		return
+	}
+	problem := func(format string, args ...interface{}) {
+		name := path
+		if name == "" {
+			name = "config"
+		}
+		*problems = append(*problems, fmt.Sprintf("line %d: %s: %s", node.Line, name, fmt.Sprintf(format, args...)))
+	}
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			problem("must be a mapping")
+			return
+		}
+		fields := map[string]reflect.Type{}
+		var names []string
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			fields[name] = t.Field(i).Type
+			names = append(names, name)
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				msg := fmt.Sprintf("line %d: %s: unknown field", key.Line, joinPath(path, key.Value))
+				if suggestion := closest(key.Value, names); suggestion != "" {
+					msg += fmt.Sprintf(", did you mean %q?", suggestion)
+				}
+				*problems = append(*problems, msg)
+				continue
+			}
+			checkNode(value, fieldType, joinPath(path, key.Value), problems)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			problem("must be a mapping")
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			checkNode(node.Content[i+1], t.Elem(), joinPath(path, node.Content[i].Value), problems)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			problem("must be a list")
+			return
+		}
+		for i, item := range node.Content {
+			checkNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			problem("must be a string")
+		}
+	case reflect.Int:
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!int" {
+			problem("must be an integer, not %q", nodeText(node))
+		}
+	case reflect.Bool:
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!bool" {
+			problem("must be true or false, not %q", nodeText(node))
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// nodeText() returns a short description of node's value for an error.
+func nodeText(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return node.Value
+}
+
+// closest() returns the name in names nearest to s, if one is close
+// enough to be a likely typo.
+func closest(s string, names []string) string {
+	best, bestDistance := "", 3
+	for _, name := range names {
+		if d := editDistance(s, name); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+// editDistance() is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
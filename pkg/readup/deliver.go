@@ -0,0 +1,155 @@
+package readup
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Delivery sends the diff of a run, and a summary of which blocks
+// changed, to people who weren't watching it, e.g. so a nightly job
+// checking that docs are fresh tells the team exactly which examples
+// drifted. The config's deliver section chooses an email, a webhook, or
+// both:
+//
+//	deliver:
+//	  webhook: https://hooks.slack.com/services/...
+//	  format: slack
+//	  email:
+//	    to: [docs@example.com]
+//	    from: readup@example.com
+//	    smtp: smtp.example.com:587
+//	    username: readup
+//	    password_env: SMTP_PASSWORD
+//
+// Nothing is sent if no block changed, unless always is set. The
+// password is read from the environment variable password_env names so
+// it needn't be kept in the config.
+
+// deliverConfig is the deliver section of the config.
+type deliverConfig struct {
+	Webhook string      `yaml:"webhook"`
+	Format  string      `yaml:"format"`
+	Email   emailConfig `yaml:"email"`
+	Always  bool        `yaml:"always"`
+}
+
+type emailConfig struct {
+	To          []string `yaml:"to"`
+	From        string   `yaml:"from"`
+	SMTP        string   `yaml:"smtp"`
+	Username    string   `yaml:"username"`
+	PasswordEnv string   `yaml:"password_env"`
+}
+
+// changeReport is the JSON a delivery webhook is sent.
+type changeReport struct {
+	File    string       `json:"file"`
+	Time    string       `json:"time"`
+	Blocks  int          `json:"blocks"`
+	Changed []jsonChange `json:"changed"`
+	Diff    string       `json:"diff"`
+}
+
+// check() returns an error if the config's deliver section is invalid.
+func (c deliverConfig) check() error {
+	if c.Format != "" && !contains(notifyFormats, c.Format) {
+		return fmt.Errorf("unknown deliver format %q, must be one of %s", c.Format, strings.Join(notifyFormats, ", "))
+	}
+	if len(c.Email.To) > 0 && (c.Email.From == "" || c.Email.SMTP == "") {
+		return fmt.Errorf("deliver email needs from and smtp as well as to")
+	}
+	return nil
+}
+
+// deliverChanges() sends the changes a run of filename made, as the
+// unified diff and results, where cfg says to.
+func deliverChanges(cfg deliverConfig, filename string, results []*blockResult, diff string) error {
+	if cfg.Webhook == "" && len(cfg.Email.To) == 0 {
+		return nil
+	}
+
+	report := changeReport{
+		File:    filename,
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Blocks:  len(results),
+		Changed: []jsonChange{},
+		Diff:    diff,
+	}
+	for _, result := range results {
+		if result.stale() {
+			report.Changed = append(report.Changed, jsonChange{Line: result.line, Command: result.command})
+		}
+	}
+	if len(report.Changed) == 0 && diff == "" && !cfg.Always {
+		return nil
+	}
+	subject, summary := changeSummary(report)
+
+	if cfg.Webhook != "" {
+		var payload interface{} = report
+		if cfg.Format == "slack" {
+			text := "*" + subject + "*\n" + summary
+			if diff != "" {
+				text += "\n```\n" + strings.TrimRight(diff, "\n") + "\n```"
+			}
+			payload = map[string]string{"text": text}
+		}
+		if err := postWebhook(cfg.Webhook, payload); err != nil {
+			return err
+		}
+	}
+	if len(cfg.Email.To) > 0 {
+		body := summary + "\n"
+		if diff != "" {
+			body += "\n" + diff
+		}
+		if err := sendEmail(cfg.Email, subject, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changeSummary() returns a subject line and a list of the changed
+// blocks in report.
+func changeSummary(report changeReport) (string, string) {
+	if len(report.Changed) == 0 {
+		return fmt.Sprintf("readup: %s is up to date", report.File),
+			fmt.Sprintf("All %d blocks are up to date", report.Blocks)
+	}
+
+	subject := fmt.Sprintf("readup: %d of %d blocks in %s changed", len(report.Changed), report.Blocks, report.File)
+	var lines []string
+	for _, change := range report.Changed {
+		command, _, _ := strings.Cut(change.Command, "\n")
+		lines = append(lines, fmt.Sprintf("line %d: %s", change.Line, command))
+	}
+	return subject, strings.Join(lines, "\n")
+}
+
+// sendEmail() sends a plain text email through cfg's SMTP server,
+// authenticating if it has a username.
+func sendEmail(cfg emailConfig, subject, body string) error {
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host, _, _ := strings.Cut(cfg.SMTP, ":")
+		auth = smtp.PlainAuth("", cfg.Username, os.Getenv(cfg.PasswordEnv), host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	if err := smtp.SendMail(cfg.SMTP, auth, cfg.From, cfg.To, []byte(msg.String())); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package readup
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// document is a parsed README: a sequence of plain lines and fenced code
+// blocks, which can be rendered back to exactly the original text.
+type document struct {
+	nodes []*node
+	// trailingNewline is set if the file ended with a newline
+	trailingNewline bool
+}
+
+// node is either a single line of text outside any code block, or a
+// code block.
+type node struct {
+	text  string
+	block *codeBlock
+}
+
+// codeBlock is a fenced code block. Its lines are kept as they are in
+// the file, including the prefix of any blockquote the block is in.
+type codeBlock struct {
+	// line is the line number of the opening fence
+	line  int
+	fence string
+	body  []string
+	// closing is the closing fence, or empty if the block runs to the
+	// end of the file
+	closing string
+	// marker is the run of backticks or tildes the opening fence starts
+	// with, which the closing fence has to match
+	marker string
+	// prefix is what comes before the marker on the opening fence: the
+	// "> " of a blockquote and any indentation
+	prefix string
+	// inList is set if the block is indented under a list item, so a
+	// line indented less than the fence ends it
+	inList bool
+	// hidden is the comment holding the command of a block with
+	// echo=hidden, which is written just above the fence
+	hidden []string
+
+	lang  string
+	attrs blockAttrs
+}
+
+// parseDocument() splits data into lines and code blocks. A code block
+// starts with a fence of three or more backticks or tildes, and ends at
+// a line of at least as many of the same character, or where the
+// blockquote or list item it's in ends. Fences inside HTML comments and
+// other raw HTML are left as text, since Markdown doesn't treat them as
+// fences either, as are fences indented four or more spaces outside a
+// list, which are part of an indented code block.
+func parseDocument(data []byte) *document {
+	doc := &document{trailingNewline: bytes.HasSuffix(data, []byte("\n"))}
+
+	var block *codeBlock
+	// html, if set, reports whether a line ends the raw HTML the
+	// previous lines started
+	var html func(string) bool
+	afterBlank := true
+	inList := false
+	lineNo := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNo++
+
+		if html != nil {
+			if html(line) {
+				html = nil
+			}
+			doc.nodes = append(doc.nodes, &node{text: line})
+			afterBlank = strings.TrimSpace(line) == ""
+			continue
+		}
+
+		if block != nil {
+			content, inside := block.strip(line)
+			if inside {
+				if isClosingFence(content, block.marker) {
+					block.closing = line
+					block = nil
+				} else {
+					block.body = append(block.body, line)
+				}
+				continue
+			}
+			// the blockquote ended without closing the block
+			block = nil
+		}
+
+		prefix := quotePrefix(line)
+		indent := len(line[len(prefix):]) - len(strings.TrimLeft(line[len(prefix):], " "))
+		if indent < 4 || inList {
+			prefix = line[:len(prefix)+indent]
+			if marker := fenceMarker(line[len(prefix):]); marker != "" {
+				block = &codeBlock{line: lineNo, fence: line, marker: marker, prefix: prefix, inList: inList && indent > 0}
+				block.lang, block.attrs = parseFence(line[len(prefix):])
+				if block.attrs["echo"] == "hidden" {
+					doc.nodes = takeHiddenCommand(doc.nodes, block)
+				}
+				doc.nodes = append(doc.nodes, &node{block: block})
+				afterBlank = true
+				continue
+			}
+		}
+
+		if listItemPattern.MatchString(line) {
+			inList = true
+		} else if line != "" && line[0] != ' ' {
+			inList = false
+		}
+		html = htmlBlock(line, afterBlank)
+		doc.nodes = append(doc.nodes, &node{text: line})
+		afterBlank = strings.TrimSpace(line) == ""
+	}
+	return doc
+}
+
+// quotePattern matches the markers of the blockquotes, possibly nested,
+// at the start of a line.
+var quotePattern = regexp.MustCompile(`^(?: {0,3}> ?)*`)
+
+func quotePrefix(line string) string {
+	return quotePattern.FindString(line)
+}
+
+// listItemPattern matches the first line of a list item.
+var listItemPattern = regexp.MustCompile(`^(?: {0,3}> ?)* *(?:[-*+]|[0-9]{1,9}[.)])(?: |$)`)
+
+// strip() returns line without the block's prefix, and whether line is
+// part of the blockquote or list item the block is in at all. Blank
+// lines in a blockquote are often just ">", and nested quotes may be
+// spaced differently, so only the number of quote markers has to match.
+// Like Markdown, lines indented less than the fence lose what
+// indentation they have.
+func (b *codeBlock) strip(line string) (string, bool) {
+	if strings.HasPrefix(line, b.prefix) {
+		return line[len(b.prefix):], true
+	}
+
+	quote := quotePrefix(line)
+	if strings.Count(quote, ">") != strings.Count(quotePrefix(b.prefix), ">") {
+		return "", false
+	}
+	rest := line[len(quote):]
+	spaces := len(rest) - len(strings.TrimLeft(rest, " "))
+	indent := len(b.prefix) - len(quotePrefix(b.prefix))
+	switch {
+	case spaces >= indent:
+		return rest[indent:], true
+	case strings.TrimSpace(rest) == "":
+		return "", true
+	case b.inList:
+		// a line less indented than the fence ends the list item
+		return "", false
+	}
+	return rest[spaces:], true
+}
+
+// fencePattern matches an opening fence, which for backticks can't have
+// any more backticks after the marker.
+var fencePattern = regexp.MustCompile("^(`{3,})[^`]*$|^(~{3,})")
+
+// fenceMarker() returns the backticks or tildes starting line if it's an
+// opening fence, or "" if it isn't.
+func fenceMarker(line string) string {
+	m := fencePattern.FindStringSubmatch(line)
+	if m == nil {
+		return ""
+	}
+	return m[1] + m[2]
+}
+
+// isClosingFence() reports whether line closes a block opened with
+// marker: at least as many of the same character, and nothing else.
+func isClosingFence(line, marker string) bool {
+	line = strings.TrimRight(line, " \t")
+	return len(line) >= len(marker) && strings.Trim(line, marker[:1]) == ""
+}
+
+// These start the kinds of raw HTML block CommonMark recognizes, and the
+// patterns after them end the first five kinds. The last two end at a
+// blank line.
+var (
+	htmlLiteralStart = regexp.MustCompile(`(?i)^ {0,3}<(pre|script|style|textarea)(\s|>|$)`)
+	htmlLiteralEnd   = regexp.MustCompile(`(?i)</(pre|script|style|textarea)>`)
+	htmlOthers       = []struct{ start, end string }{
+		{"<!--", "-->"},
+		{"<?", "?>"},
+		{"<![CDATA[", "]]>"},
+		{"<!", ">"},
+	}
+	htmlTagStart      = regexp.MustCompile(`^ {0,3}</?([A-Za-z][A-Za-z0-9-]*)(\s|/?>|$)`)
+	htmlCompleteTag   = regexp.MustCompile(`^ {0,3}(<[A-Za-z][A-Za-z0-9-]*(\s[^<>]*)?/?>|</[A-Za-z][A-Za-z0-9-]*\s*>)\s*$`)
+	htmlBlockElements = map[string]bool{}
+)
+
+func init() {
+	for _, tag := range strings.Fields(`address article aside base basefont
+		blockquote body caption center col colgroup dd details dialog dir div
+		dl dt fieldset figcaption figure footer form frame frameset h1 h2 h3
+		h4 h5 h6 head header hr html iframe legend li link main menu menuitem
+		nav noframes ol optgroup option p param search section summary table
+		tbody td tfoot th thead title tr track ul`) {
+		htmlBlockElements[tag] = true
+	}
+}
+
+// htmlBlock() returns, if line starts a raw HTML block that continues
+// past it, a function that reports whether a later line ends the block.
+// afterBlank is set if line doesn't continue a paragraph, which some
+// HTML can't interrupt.
+func htmlBlock(line string, afterBlank bool) func(string) bool {
+	ends := func(pattern string) func(string) bool {
+		return func(l string) bool { return strings.Contains(l, pattern) }
+	}
+	blank := func(l string) bool { return strings.TrimSpace(l) == "" }
+
+	if htmlLiteralStart.MatchString(line) {
+		if htmlLiteralEnd.MatchString(line) {
+			return nil
+		}
+		return htmlLiteralEnd.MatchString
+	}
+
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) <= 3 {
+		for _, h := range htmlOthers {
+			if strings.HasPrefix(trimmed, h.start) {
+				if strings.Contains(trimmed[len(h.start):], h.end) {
+					return nil
+				}
+				return ends(h.end)
+			}
+		}
+	}
+
+	if m := htmlTagStart.FindStringSubmatch(line); m != nil && htmlBlockElements[strings.ToLower(m[1])] {
+		return blank
+	}
+	if afterBlank && htmlCompleteTag.MatchString(line) {
+		return blank
+	}
+	return nil
+}
+
+// clone() returns a copy of the block that changing the block won't
+// affect.
+func (b *codeBlock) clone() *codeBlock {
+	c := *b
+	c.body = append([]string(nil), b.body...)
+	c.hidden = append([]string(nil), b.hidden...)
+	c.attrs = blockAttrs{}
+	for key, value := range b.attrs {
+		c.attrs[key] = value
+	}
+	return &c
+}
+
+// lastLine() returns the line number of the block's closing fence.
+func (b *codeBlock) lastLine() int {
+	return b.line + len(b.body) + 1
+}
+
+func (b *codeBlock) lines() []string {
+	lines := append(append([]string{}, b.hidden...), b.fence)
+	lines = append(lines, b.body...)
+	if b.closing != "" {
+		lines = append(lines, b.closing)
+	}
+	return lines
+}
+
+// content() returns the lines between the block's fences, without the
+// prefix.
+func (b *codeBlock) content() []string {
+	lines := make([]string, len(b.body))
+	for i, line := range b.body {
+		lines[i], _ = b.strip(line)
+	}
+	return lines
+}
+
+// isCommand() reports whether the block is a '> [command]' block, or
+// has a command shown in another echo style.
+func (b *codeBlock) isCommand() bool {
+	lines := b.commandLines()
+	return b.closing != "" && len(lines) > 0 && strings.HasPrefix(lines[0], "> ")
+}
+
+// setOutput() replaces everything in the block after its first n lines
+// with output, adding the block's prefix to each line.
+func (b *codeBlock) setOutput(n int, output string) {
+	b.body = b.body[:n:n]
+	for _, line := range strings.Split(output, "\n") {
+		b.body = append(b.body, b.prefixed(line))
+	}
+}
+
+// prefixed() returns line with the block's prefix, leaving no trailing
+// space on a blank line.
+func (b *codeBlock) prefixed(line string) string {
+	if line == "" {
+		return strings.TrimRight(b.prefix, " ")
+	}
+	return b.prefix + line
+}
+
+// render() returns the document's text.
+func (d *document) render() string {
+	var lines []string
+	for _, n := range d.nodes {
+		if n.block != nil {
+			lines = append(lines, n.block.lines()...)
+		} else {
+			lines = append(lines, n.text)
+		}
+	}
+
+	content := strings.Join(lines, "\n")
+	if d.trailingNewline {
+		content += "\n"
+	}
+	return content
+}
@@ -0,0 +1,135 @@
+package readup
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testBlock is a code block parseDocument() should find.
+type testBlock struct {
+	line    int
+	content []string
+	closed  bool
+}
+
+type documentTest struct {
+	name   string
+	in     string
+	blocks []testBlock
+}
+
+func testParseDocument(t *testing.T, tests []documentTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := parseDocument([]byte(tt.in))
+			if got := doc.render(); got != tt.in {
+				t.Errorf("render() = %q, want %q", got, tt.in)
+			}
+			var blocks []testBlock
+			for _, n := range doc.nodes {
+				if n.block != nil {
+					blocks = append(blocks, testBlock{n.block.line, n.block.content(), n.block.closing != ""})
+				}
+			}
+			if !reflect.DeepEqual(blocks, tt.blocks) {
+				t.Errorf("blocks = %+v, want %+v", blocks, tt.blocks)
+			}
+		})
+	}
+}
+
+func TestParseDocument(t *testing.T) {
+	testParseDocument(t, []documentTest{
+		{"no blocks", "# Title\n\ntext\n", nil},
+		{"no trailing newline", "text", nil},
+		{"block", "# T\n\n```\n> echo hi\nhi\n```\n\nafter\n", []testBlock{{3, []string{"> echo hi", "hi"}, true}}},
+		{"two blocks", "```\na\n```\n```sh\nb\n```\n", []testBlock{{1, []string{"a"}, true}, {4, []string{"b"}, true}}},
+		{"empty block", "```\n```\n", []testBlock{{1, []string{}, true}}},
+		{"unclosed at the end", "text\n```\n> echo hi\nhi\n", []testBlock{{2, []string{"> echo hi", "hi"}, false}}},
+		{"unclosed without a newline", "```\n> echo hi", []testBlock{{1, []string{"> echo hi"}, false}}},
+	})
+}
+
+func TestParseDocumentHTML(t *testing.T) {
+	testParseDocument(t, []documentTest{
+		{"comment", "<!--\n```\n> rm -rf /\n```\n-->\n```\na\n```\n", []testBlock{{6, []string{"a"}, true}}},
+		{"comment on one line", "<!-- note -->\n```\na\n```\n", []testBlock{{2, []string{"a"}, true}}},
+		{"comment ending mid line", "<!-- start\n```\nend --> text\n```\na\n```\n", []testBlock{{4, []string{"a"}, true}}},
+		{"pre", "<pre>\n```\n> echo hi\n```\n</pre>\n", nil},
+		{"pre then block", "<pre>\n```\n</pre>\n```\na\n```\n", []testBlock{{4, []string{"a"}, true}}},
+		{"div ends at a blank line", "<div>\n```\nnot a block\n\n```\na\n```\n", []testBlock{{5, []string{"a"}, true}}},
+		{"details", "<details>\n<summary>More</summary>\n\n```\na\n```\n\n</details>\n", []testBlock{{4, []string{"a"}, true}}},
+		{"inline html isn't a block", "some <span>text</span>\n```\na\n```\n", []testBlock{{2, []string{"a"}, true}}},
+		{"complete tag can't interrupt a paragraph", "text\n<custom>\n```\na\n```\n", []testBlock{{3, []string{"a"}, true}}},
+		{"complete tag after a blank line", "text\n\n<custom>\n```\nnot a block\n", nil},
+	})
+}
+
+func TestParseDocumentFences(t *testing.T) {
+	testParseDocument(t, []documentTest{
+		{"tildes", "~~~\n> echo hi\nhi\n~~~\n", []testBlock{{1, []string{"> echo hi", "hi"}, true}}},
+		{"longer fence", "````sh\na\n````\n", []testBlock{{1, []string{"a"}, true}}},
+		{"short fence inside a long one", "````markdown\n```\n> echo hi\n```\n````\n", []testBlock{{1, []string{"```", "> echo hi", "```"}, true}}},
+		{"longer closing fence", "```\na\n`````\n", []testBlock{{1, []string{"a"}, true}}},
+		{"closing fence with trailing space", "```\na\n```  \n", []testBlock{{1, []string{"a"}, true}}},
+		{"tildes don't close backticks", "```\n~~~\n```\n", []testBlock{{1, []string{"~~~"}, true}}},
+		{"backticks don't close tildes", "~~~\n```\n~~~\n", []testBlock{{1, []string{"```"}, true}}},
+		{"closing fence can't have an info string", "```\n```sh\n```\n", []testBlock{{1, []string{"```sh"}, true}}},
+		{"backticks in a backtick info string", "``` a`b\n", nil},
+		{"backticks in a tilde info string", "~~~ a`b\nx\n~~~\n", []testBlock{{1, []string{"x"}, true}}},
+		{"two backticks", "``\nx\n``\n", nil},
+	})
+}
+
+func TestSetOutputKeepsFences(t *testing.T) {
+	for in, want := range map[string]string{
+		"~~~\n> echo hi\nold\n~~~\n":          "~~~\n> echo hi\nhi\n~~~\n",
+		"````sh\n> echo hi\n```\nold\n````\n": "````sh\n> echo hi\nhi\n````\n",
+	} {
+		doc := parseDocument([]byte(in))
+		doc.nodes[0].block.setOutput(1, "hi")
+		if got := doc.render(); got != want {
+			t.Errorf("rewriting %q = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseDocumentBlockquotes(t *testing.T) {
+	testParseDocument(t, []documentTest{
+		{"quoted block", "> ```\n> > echo hi\n> hi\n> ```\n", []testBlock{{1, []string{"> echo hi", "hi"}, true}}},
+		{"bare > blank line", "> ```\n> > echo hi\n>\n> hi\n> ```\n", []testBlock{{1, []string{"> echo hi", "", "hi"}, true}}},
+		{"without the space", ">```\n>a\n>```\n", []testBlock{{1, []string{"a"}, true}}},
+		{"nested", "> > ```\n> > a\n>> ```\n", []testBlock{{1, []string{"a"}, true}}},
+		{"quote ends the block", "> ```\n> a\nafter\n```\nb\n```\n", []testBlock{{1, []string{"a"}, false}, {4, []string{"b"}, true}}},
+		{"less nested quote ends the block", "> > ```\n> > a\n> b\n", []testBlock{{1, []string{"a"}, false}}},
+		{"unquoted block after a quote", "> quote\n\n```\n> echo hi\n```\n", []testBlock{{3, []string{"> echo hi"}, true}}},
+	})
+
+	doc := parseDocument([]byte("> ```\n> > echo hi\n> old\n>\n> ```\n"))
+	doc.nodes[0].block.setOutput(1, "a\n\nb")
+	if got, want := doc.render(), "> ```\n> > echo hi\n> a\n>\n> b\n> ```\n"; got != want {
+		t.Errorf("rewriting a quoted block = %q, want %q", got, want)
+	}
+}
+
+func TestParseDocumentLists(t *testing.T) {
+	testParseDocument(t, []documentTest{
+		{"list item block", "1. Run:\n\n   ```\n   > echo hi\n   hi\n   ```\n", []testBlock{{3, []string{"> echo hi", "hi"}, true}}},
+		{"deeply indented list item block", "- a\n  - b\n\n        ```\n        x\n        ```\n", []testBlock{{4, []string{"x"}, true}}},
+		{"less indented line ends the item", "- Run:\n  ```\n  a\nnext\n", []testBlock{{2, []string{"a"}, false}}},
+		{"less indented blank line doesn't", "- Run:\n  ```\n  a\n\n  b\n  ```\n", []testBlock{{2, []string{"a", "", "b"}, true}}},
+		{"closing fence at the item's indent", "* x\n\n  ```\n  a\n  ```\n* y\n", []testBlock{{3, []string{"a"}, true}}},
+		{"list item in a quote", "> - Run:\n>   ```\n>   a\n>   ```\n", []testBlock{{2, []string{"a"}, true}}},
+		{"indented code", "text\n\n    ```\n    not a block\n    ```\n", nil},
+		{"indented code after a list", "- item\n\nparagraph\n\n    ```\n    x\n", nil},
+		{"three spaces is a fence", "   ```\n   a\n   ```\n", []testBlock{{1, []string{"a"}, true}}},
+		{"outside a list, less indented lines lose their indent", "  ```\na\n   b\n  ```\n", []testBlock{{1, []string{"a", " b"}, true}}},
+	})
+
+	doc := parseDocument([]byte("1. Run:\n\n   ```\n   > echo hi\n   old\n   ```\n"))
+	doc.nodes[2].block.setOutput(1, "a\n\nb")
+	if got, want := doc.render(), "1. Run:\n\n   ```\n   > echo hi\n   a\n\n   b\n   ```\n"; got != want {
+		t.Errorf("rewriting a list item block = %q, want %q", got, want)
+	}
+}
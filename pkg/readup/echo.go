@@ -0,0 +1,123 @@
+package readup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A block's echo attribute, or the config's echo setting, chooses how
+// its command is shown once it's run: "prompt" leaves the "> command"
+// line as it is, "dollar" shows it as "$ command", and "hidden" leaves
+// only the output in the block, keeping the command in a comment just
+// above it. Either of the last two is written to the block's fence, so
+// readup still recognizes the block next time.
+
+var echoStyles = []string{"prompt", "dollar", "hidden"}
+
+const (
+	hiddenCommandStart = "<!-- readup-command: "
+	hiddenCommandEnd   = " -->"
+)
+
+// commandLines() returns the lines holding the block's command, as they
+// would be written in the "> command" style, followed by the rest of the
+// block's content.
+func (b *codeBlock) commandLines() []string {
+	lines := b.content()
+	if len(b.hidden) > 0 {
+		return append(hiddenCommand(b.hidden, b.strip), lines...)
+	}
+	if b.attrs["echo"] == "dollar" && len(lines) > 0 && strings.HasPrefix(lines[0], "$ ") {
+		lines[0] = "> " + strings.TrimPrefix(lines[0], "$ ")
+	}
+	return lines
+}
+
+// setCommand() replaces the first n of the block's commandLines() with
+// command, shown in style, and returns how many lines of the body the
+// command now takes up.
+func (b *codeBlock) setCommand(command []string, n int, style string) (int, error) {
+	rest := b.body[n-len(b.hidden):]
+	if style == "prompt" && len(b.hidden) == 0 {
+		return len(b.body) - len(rest), nil
+	}
+
+	b.setEcho(style)
+
+	var header []string
+	b.hidden = nil
+	switch style {
+	case "hidden":
+		lines := append([]string{}, command...)
+		lines[0] = hiddenCommandStart + strings.TrimPrefix(lines[0], "> ")
+		lines[len(lines)-1] += hiddenCommandEnd
+		for _, line := range lines {
+			if strings.Contains(line, "-->") && !strings.HasSuffix(line, hiddenCommandEnd) {
+				return 0, fmt.Errorf("can't hide a command containing \"-->\"")
+			}
+			b.hidden = append(b.hidden, b.prefixed(line))
+		}
+	case "dollar":
+		header = append(header, b.prefixed("$ "+strings.TrimPrefix(command[0], "> ")))
+		for _, line := range command[1:] {
+			header = append(header, b.prefixed(line))
+		}
+	default:
+		for _, line := range command {
+			header = append(header, b.prefixed(line))
+		}
+	}
+
+	b.body = append(header, rest...)
+	return len(header), nil
+}
+
+// setEcho() records style on the block's fence, unless it's the
+// default.
+func (b *codeBlock) setEcho(style string) {
+	if style != "prompt" && b.attrs["echo"] != style {
+		b.fence = strings.TrimRight(b.fence, " \t") + " echo=" + style
+		b.attrs["echo"] = style
+	}
+}
+
+// hiddenCommand() returns the command held in the comment lines above a
+// hidden block, in the "> command" style, stripping each line with
+// strip.
+func hiddenCommand(comment []string, strip func(string) (string, bool)) []string {
+	if len(comment) == 0 {
+		return nil
+	}
+	lines := make([]string, len(comment))
+	for i, line := range comment {
+		lines[i], _ = strip(line)
+	}
+	lines[0] = "> " + strings.TrimPrefix(lines[0], hiddenCommandStart)
+	lines[len(lines)-1] = strings.TrimSuffix(lines[len(lines)-1], hiddenCommandEnd)
+	return lines
+}
+
+// takeHiddenCommand() removes the comment holding a hidden block's
+// command from the end of nodes and gives it to block.
+func takeHiddenCommand(nodes []*node, block *codeBlock) []*node {
+	end := len(nodes) - 1
+	if end < 0 || nodes[end].block != nil || !strings.HasSuffix(nodes[end].text, hiddenCommandEnd) {
+		return nodes
+	}
+	for i := end; i >= 0 && nodes[i].block == nil; i-- {
+		line, inside := block.strip(nodes[i].text)
+		if !inside {
+			break
+		}
+		if strings.HasPrefix(line, hiddenCommandStart) {
+			for _, n := range nodes[i:] {
+				block.hidden = append(block.hidden, n.text)
+			}
+			return nodes[:i]
+		}
+		if i < end && strings.Contains(line, "-->") {
+			break
+		}
+	}
+	return nodes
+}
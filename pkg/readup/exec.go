@@ -0,0 +1,328 @@
+package readup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/creack/pty"
+)
+
+// execOptions control how execCommand() runs a command.
+type execOptions struct {
+	// baseEnv is the environment the command starts from, or nil to
+	// inherit the current process's
+	baseEnv []string
+	// env overrides variables inherited from the current process
+	env []string
+	// maxOutput kills the command once it has written more than this
+	// many bytes, if it's not 0
+	maxOutput int64
+	// print echoes the command and its output
+	print bool
+	// term sets TERM if it's not empty
+	term string
+	// columns and lines set the size of the PTY, and COLUMNS and LINES
+	// if they're not 0
+	columns, lines int
+	// capture is how output is read, one of captureModes
+	capture string
+	// shell runs the command with -c, or defaultShell if it's empty
+	shell string
+	// runner, if set, is a command that runs the shell somewhere else,
+	// see blockRunner()
+	runner []string
+	// umask is set by the shell before it runs the command, if it's not
+	// empty
+	umask string
+	// noControllingTerminal runs the command in a PTY that isn't its
+	// controlling terminal, so opening /dev/tty fails
+	noControllingTerminal bool
+	// dir is the directory the command runs in, or "" for the current
+	// one
+	dir string
+	// highlight is the language to highlight echoed output as, or "" to
+	// echo it in the output color
+	highlight string
+}
+
+const (
+	defaultShell = "/bin/sh"
+
+	defaultColumns = 80
+	defaultLines   = 40
+
+	// wideColumns is the PTY width in "wide" capture mode, where tools
+	// that wrap or truncate to the terminal width shouldn't
+	wideColumns = 10000
+)
+
+// captureModes are the ways a command's output can be captured: "pty"
+// runs it in a terminal, "wide" in a very wide terminal, and "pipe"
+// without a terminal, reading stdout and stderr from a pipe.
+var captureModes = []string{"pty", "wide", "pipe"}
+
+// languageShells are the shells for blocks whose language isn't run by
+// a POSIX shell, when the block doesn't choose a shell itself.
+var languageShells = map[string]string{
+	"powershell": windowsPowerShell,
+	"ps1":        windowsPowerShell,
+	"pwsh":       "pwsh",
+}
+
+func init() {
+	if runtime.GOOS == "windows" {
+		languageShells["cmd"] = "cmd"
+		languageShells["bat"] = "cmd"
+		languageShells["batch"] = "cmd"
+	}
+}
+
+// shellCommand() returns a command that has shell run cmd. PowerShell
+// and cmd.exe take their commands differently, and are told to write
+// UTF-8 rather than the console's code page.
+func shellCommand(shell, cmd string) *exec.Cmd {
+	if shell == "" {
+		shell = defaultShell
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(shell), filepath.Ext(shell)))
+	switch name {
+	case "powershell", "pwsh":
+		return exec.Command(shell, "-NoProfile", "-NonInteractive", "-Command",
+			"[Console]::OutputEncoding = [System.Text.Encoding]::UTF8; "+cmd)
+	case "cmd":
+		command := exec.Command(shell)
+		// cmd.exe doesn't parse its arguments the way Go quotes them
+		setCommandLine(command, fmt.Sprintf(`%s /S /C "chcp 65001 >NUL & %s"`, shell, cmd))
+		return command
+	}
+	return exec.Command(shell, "-c", cmd)
+}
+
+// checkText() returns an error if out looks like binary data rather than
+// text: invalid UTF-8, or control characters other than whitespace,
+// backspace and escape sequences.
+func checkText(out []byte) error {
+	for i := 0; i < len(out); {
+		r, size := utf8.DecodeRune(out[i:])
+		if r == utf8.RuneError && size == 1 {
+			return fmt.Errorf("wrote binary output (invalid UTF-8 at byte %d)", i)
+		}
+		if r < 0x20 && !strings.ContainsRune("\t\n\r\b\f\v\x1b\a", r) {
+			return fmt.Errorf("wrote binary output (control character %#02x at byte %d)", r, i)
+		}
+		i += size
+	}
+	return nil
+}
+
+// errOutputTooLarge is returned when a command exceeds maxOutput.
+var errOutputTooLarge = errors.New("output too large")
+
+// execCommand() is a helper function that runs a command in a PTY
+// (unless capturing from a pipe) and returns the output and the
+// command's exit status. A command exiting with a non-zero status isn't
+// an error here, it's up to the caller whether that's expected.
+func execCommand(cmd string, eo execOptions) (string, int, error) {
+	if eo.print {
+		fmt.Printf("%s %s\n", paint(colors.label, "Running:"), paint(colors.command, cmd))
+	}
+	stream := &streamWriter{w: io.Discard, highlight: highlighters[eo.highlight]}
+	if eo.print {
+		stream.w = os.Stdout
+	}
+
+	script := cmd
+	if eo.umask != "" {
+		script = fmt.Sprintf("umask %s; %s", eo.umask, cmd)
+	}
+	command := shellCommand(eo.shell, script)
+	if len(eo.runner) > 0 {
+		wrapped := exec.Command(eo.runner[0], append(eo.runner[1:], command.Args...)...)
+		wrapCommandLine(wrapped, command)
+		command = wrapped
+	}
+
+	// copy PATH env var from current process
+	base := eo.baseEnv
+	if base == nil {
+		base = os.Environ()
+	}
+	command.Env = append(append([]string{}, base...), "PATH="+os.Getenv("PATH"))
+	command.Env = append(command.Env, eo.env...)
+	command.Dir = eo.dir
+
+	winSize := &pty.Winsize{Rows: defaultLines, Cols: defaultColumns}
+	if eo.term != "" {
+		command.Env = append(command.Env, "TERM="+eo.term)
+	}
+	if eo.capture == "wide" {
+		winSize.Cols = wideColumns
+	}
+	if eo.columns != 0 {
+		winSize.Cols = uint16(eo.columns)
+		command.Env = append(command.Env, fmt.Sprintf("COLUMNS=%d", eo.columns))
+	}
+	if eo.lines != 0 {
+		winSize.Rows = uint16(eo.lines)
+		command.Env = append(command.Env, fmt.Sprintf("LINES=%d", eo.lines))
+	}
+
+	var output io.ReadCloser
+	if eo.capture == "pipe" {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			return "", 0, err
+		}
+		command.Stdout = writer
+		command.Stderr = writer
+		setProcessGroup(command)
+
+		err = command.Start()
+		writer.Close()
+		if err != nil {
+			reader.Close()
+			return "", 0, err
+		}
+		output = reader
+	} else {
+		ptyFile, err := startPTY(command, winSize, !eo.noControllingTerminal)
+		if err != nil {
+			return "", 0, err
+		}
+		output = ptyFile
+	}
+	defer output.Close()
+	addRunning(command.Process)
+	defer removeRunning(command.Process)
+
+	if eo.print {
+		fmt.Println(paint(colors.label, "Output:"))
+	}
+	defer stream.Close()
+
+	var out []byte
+	buf := make([]byte, 1024)
+	for {
+		n, err := output.Read(buf)
+		// Linux returns EIO rather than EOF once the child has exited
+		if err != nil && err != io.EOF && !errors.Is(err, syscall.EIO) {
+			return "", 0, err
+		}
+		if n == 0 {
+			break
+		}
+		out = append(out, buf[:n]...)
+		stream.Write(buf[:n])
+
+		if eo.maxOutput > 0 && int64(len(out)) > eo.maxOutput {
+			killProcessGroup(command.Process)
+			command.Wait()
+			return "", 0, fmt.Errorf("%w: command %q wrote more than %d bytes and was killed", errOutputTooLarge, cmd, eo.maxOutput)
+		}
+	}
+
+	exitCode := 0
+	if err := command.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return "", 0, err
+		}
+		exitCode = exitErr.ExitCode()
+	}
+
+	if err := checkText(out); err != nil {
+		return "", 0, fmt.Errorf("command %q %w, refusing to embed it", cmd, err)
+	}
+
+	return emulateTerminal(string(out)), exitCode, nil
+}
+
+// streamWriter echoes a command's output as it's produced, indented and
+// colored like greyFormat(), so a slow or hung command can be seen while
+// it's still running. It passes the raw output through, so the
+// terminal rather than readup renders any cursor movement. A carriage
+// return, as a progress bar redrawing its line writes, starts the line
+// over, so the redrawn line is indented like the first.
+type streamWriter struct {
+	w io.Writer
+	// midLine is set when the last byte written wasn't a newline or
+	// carriage return
+	midLine bool
+	// highlight, if set, highlights each line, which is then held back
+	// until it's complete
+	highlight func(string) string
+	line      []byte
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.highlight != nil {
+		return s.writeHighlighted(p)
+	}
+
+	var b []byte
+	for _, c := range p {
+		if !s.midLine && c != '\n' && c != '\r' {
+			b = append(b, outputIndent...)
+			if colors.output != "" {
+				b = append(b, "\x1b["+colors.output+"m"...)
+			}
+			s.midLine = true
+		}
+		if c == '\n' || c == '\r' {
+			if s.midLine {
+				b = append(b, "\x1b[0m"...)
+			}
+			s.midLine = false
+		}
+		b = append(b, c)
+	}
+	if _, err := s.w.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeHighlighted() echoes each complete line of p highlighted.
+func (s *streamWriter) writeHighlighted(p []byte) (int, error) {
+	var b []byte
+	for _, c := range p {
+		if c != '\n' && c != '\r' {
+			s.line = append(s.line, c)
+			continue
+		}
+		if len(s.line) > 0 {
+			b = append(b, outputIndent+s.highlight(string(s.line))...)
+			s.line = s.line[:0]
+		}
+		b = append(b, c)
+	}
+	if _, err := s.w.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close() ends any unfinished line of output.
+func (s *streamWriter) Close() error {
+	if len(s.line) > 0 {
+		line := outputIndent + s.highlight(string(s.line)) + "\n"
+		s.line = nil
+		_, err := io.WriteString(s.w, line)
+		return err
+	}
+	if s.midLine {
+		s.midLine = false
+		_, err := io.WriteString(s.w, "\x1b[0m\n")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+//go:build !windows
+
+package readup
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+// defaultCapture runs commands in a PTY.
+const defaultCapture = "pty"
+
+// hookShell runs the config's hooks.
+const hookShell = defaultShell
+
+// windowsPowerShell is PowerShell for blocks tagged powershell, which
+// elsewhere is PowerShell Core.
+const windowsPowerShell = "pwsh"
+
+// killProcessGroup() kills process and anything it started. Commands
+// run in a PTY lead their own session, so process's pid is also its
+// process group id.
+func killProcessGroup(process *os.Process) {
+	if err := syscall.Kill(-process.Pid, syscall.SIGKILL); err != nil {
+		process.Kill()
+	}
+}
+
+// setProcessGroup() starts command in its own process group so that
+// killProcessGroup() reaches its children too.
+func setProcessGroup(command *exec.Cmd) {
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// startPTY() starts command in a PTY, which is its controlling terminal
+// if ctty is set.
+func startPTY(command *exec.Cmd, size *pty.Winsize, ctty bool) (*os.File, error) {
+	if ctty {
+		return pty.StartWithSize(command, size)
+	}
+	// a new session has no controlling terminal until it opens one
+	return pty.StartWithAttrs(command, size, &syscall.SysProcAttr{Setsid: true})
+}
+
+// setCommandLine() is only needed on Windows, where a command line
+// isn't a list of arguments.
+func setCommandLine(command *exec.Cmd, line string) {
+}
+
+// wrapCommandLine() is only needed on Windows, see setCommandLine().
+func wrapCommandLine(wrapper, command *exec.Cmd) {
+}
+
+// processRunning() reports whether a process with pid exists.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,72 @@
+//go:build windows
+
+package readup
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+// defaultCapture reads command output from a pipe, since there's no
+// PTY for commands on Windows.
+const defaultCapture = "pipe"
+
+// hookShell runs the config's hooks, since Windows has no /bin/sh.
+const hookShell = "cmd"
+
+// windowsPowerShell is the PowerShell that comes with Windows.
+const windowsPowerShell = "powershell"
+
+// killProcessGroup() kills process. Windows has no process groups to
+// signal, so children of process may survive it.
+func killProcessGroup(process *os.Process) {
+	process.Kill()
+}
+
+// setProcessGroup() does nothing on Windows, see killProcessGroup().
+func setProcessGroup(command *exec.Cmd) {
+}
+
+// startPTY() starts command in a PTY, which Windows doesn't have, so it
+// always fails.
+func startPTY(command *exec.Cmd, size *pty.Winsize, ctty bool) (*os.File, error) {
+	return pty.StartWithSize(command, size)
+}
+
+// setCommandLine() makes command run with exactly line as its command
+// line, rather than its arguments quoted.
+func setCommandLine(command *exec.Cmd, line string) {
+	if command.SysProcAttr == nil {
+		command.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	command.SysProcAttr.CmdLine = line
+}
+
+// wrapCommandLine() gives wrapper, a runner with command's arguments
+// after its own, command's command line if it was set, which would
+// otherwise be lost along with command.
+func wrapCommandLine(wrapper, command *exec.Cmd) {
+	if command.SysProcAttr == nil || command.SysProcAttr.CmdLine == "" {
+		return
+	}
+	var args []string
+	for _, arg := range wrapper.Args[:len(wrapper.Args)-len(command.Args)] {
+		args = append(args, syscall.EscapeArg(arg))
+	}
+	setCommandLine(wrapper, strings.Join(append(args, command.SysProcAttr.CmdLine), " "))
+}
+
+// processRunning() reports whether a process with pid exists, which on
+// Windows FindProcess() checks by opening it.
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
@@ -0,0 +1,65 @@
+package readup
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// `readup fmt` tidies the blocks readup manages without running
+// anything: fences are written with the language first and the
+// attributes sorted, closing fences match the opening one, trailing
+// whitespace goes from the fences, and commands follow a single "> ".
+
+// formatFile() returns the contents of filename with its managed blocks
+// formatted.
+func formatFile(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	doc := parseDocument(data)
+
+	for _, n := range doc.nodes {
+		block := n.block
+		if block == nil || !(block.isCommand() || block.attrs["readup"] != "") {
+			continue
+		}
+
+		block.fence = block.prefix + formatFence(block.fence[len(block.prefix):], block.marker, block.lang, block.attrs)
+		if block.closing != "" {
+			block.closing = block.prefix + block.marker
+		}
+		if block.isCommand() && len(block.hidden) == 0 && strings.HasPrefix(block.content()[0], ">") {
+			block.body[0] = block.prefix + "> " + strings.TrimSpace(strings.TrimPrefix(block.content()[0], ">"))
+		}
+	}
+	return doc.render(), nil
+}
+
+// formatFence() returns the opening fence for a block with marker, lang
+// and attrs, or fence unchanged if it has words that aren't either,
+// which formatting would lose.
+func formatFence(fence, marker, lang string, attrs blockAttrs) string {
+	words := strings.Fields(strings.TrimLeft(fence, "`~"))
+	if len(words) > 0 && words[0] == lang {
+		words = words[1:]
+	}
+	for _, word := range words {
+		if !strings.Contains(word, "=") {
+			return strings.TrimRight(fence, " \t")
+		}
+	}
+
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	formatted := marker + lang
+	for _, key := range keys {
+		formatted += " " + key + "=" + attrs[key]
+	}
+	return formatted
+}
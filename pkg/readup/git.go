@@ -0,0 +1,143 @@
+package readup
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// git() runs git in dir and returns its stdout, with stderr folded into
+// the error if it fails.
+func git(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	command := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	if err := command.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), msg)
+	}
+	return stdout.String(), nil
+}
+
+// gitIgnored() returns the files and directories under dir that git
+// ignores, relative to dir and slash-separated, with directories ending
+// in a slash. Outside a git repository nothing is ignored.
+func gitIgnored(dir string) map[string]bool {
+	out, err := git(dir, "ls-files", "-z", "--others", "--ignored", "--exclude-standard", "--directory")
+	if err != nil {
+		return nil
+	}
+	ignored := map[string]bool{}
+	for _, name := range strings.Split(out, "\x00") {
+		if name != "" {
+			ignored[name] = true
+		}
+	}
+	return ignored
+}
+
+// gitShow() returns the contents of filename as of the git revision rev.
+func gitShow(rev, filename string) (string, error) {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	// the ./ makes git resolve the path relative to dir rather than the
+	// repository root
+	return git(dir, "show", rev+":./"+base)
+}
+
+// gitCommit() stages the files names and commits them, and only them,
+// with message. They have to be in the same repository.
+func gitCommit(names []string, message string) error {
+	var paths []string
+	for _, name := range names {
+		path, err := filepath.Abs(name)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+	}
+	dir := filepath.Dir(paths[0])
+
+	if _, err := git(dir, append([]string{"add", "--"}, paths...)...); err != nil {
+		return err
+	}
+	_, err := git(dir, append([]string{"commit", "-m", message, "--"}, paths...)...)
+	return err
+}
+
+// lineRange is an inclusive range of 1-based line numbers.
+type lineRange struct {
+	start, end int
+}
+
+type lineRanges []lineRange
+
+// overlaps() reports whether any of the ranges overlap start..end.
+func (r lineRanges) overlaps(start, end int) bool {
+	for _, lr := range r {
+		if lr.start <= end && start <= lr.end {
+			return true
+		}
+	}
+	return false
+}
+
+// hunkHeader matches the new-file side of a unified diff hunk header,
+// e.g. "@@ -10,2 +12,3 @@".
+var hunkHeader = regexp.MustCompile(`^@@ -\S+ \+(\d+)(?:,(\d+))? @@`)
+
+// gitChangedLines() returns the lines of filename in the working copy
+// that differ from git revision rev. If the file doesn't exist at rev
+// then every line counts as changed, but rev itself has to exist.
+func gitChangedLines(rev, filename string) (lineRanges, error) {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+
+	if _, err := git(dir, "rev-parse", "--verify", "--quiet", rev+"^{commit}"); err != nil {
+		return nil, fmt.Errorf("can't find revision %s: %w", rev, err)
+	}
+	if _, err := git(dir, "cat-file", "-e", rev+":./"+base); err != nil {
+		return lineRanges{{1, math.MaxInt}}, nil
+	}
+
+	diff, err := git(dir, "diff", "--no-color", "-U0", rev, "--", base)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges := lineRanges{}
+	for _, line := range strings.Split(diff, "\n") {
+		match := hunkHeader.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		start, _ := strconv.Atoi(match[1])
+		count := 1
+		if match[2] != "" {
+			count, _ = strconv.Atoi(match[2])
+		}
+
+		if count == 0 {
+			// a pure deletion between line start and start+1
+			ranges = append(ranges, lineRange{start, start + 1})
+		} else {
+			ranges = append(ranges, lineRange{start, start + count - 1})
+		}
+	}
+	return ranges, nil
+}
@@ -0,0 +1,181 @@
+package readup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// The GitHub reporter posts the result of `readup --check` as a comment
+// on a pull request. Each file gets a single comment, found again on
+// later runs by a hidden marker, which is updated rather than adding a
+// new comment every time CI runs.
+
+const defaultGitHubAPI = "https://api.github.com"
+
+type githubComment struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+}
+
+// reportMarker() identifies the PR comment for filename.
+func reportMarker(filename string) string {
+	return fmt.Sprintf("<!-- readup report: %s -->", filename)
+}
+
+// codeFence() returns a backtick fence long enough to wrap s.
+func codeFence(s string) string {
+	longest, run := 0, 0
+	for _, c := range s {
+		if c == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if longest < 3 {
+		longest = 3
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+// checkReport() renders a Markdown summary of a --check run.
+func checkReport(filename string, results []*blockResult, diff string, upToDate bool) string {
+	var b strings.Builder
+	fmt.Fprintln(&b, reportMarker(filename))
+
+	if upToDate {
+		fmt.Fprintf(&b, "**readup**: all command output in `%s` is up to date.\n", filename)
+		return b.String()
+	}
+
+	var stale []*blockResult
+	for _, result := range results {
+		if result.stale() {
+			stale = append(stale, result)
+		}
+	}
+
+	fmt.Fprintf(&b, "**readup**: %d of %d blocks in `%s` are out of date.\n\n", len(stale), len(results), filename)
+	for _, result := range stale {
+		fmt.Fprintf(&b, "- line %d: `%s`\n", result.line, result.command)
+	}
+
+	fence := codeFence(diff)
+	fmt.Fprintf(&b, "\n<details><summary>Diff</summary>\n\n%sdiff\n%s\n%s\n\n</details>\n\n", fence, strings.TrimRight(diff, "\n"), fence)
+	fmt.Fprintf(&b, "Run `readup %s` to update it.\n", filename)
+	return b.String()
+}
+
+// githubReport() posts body as a comment on pull request pr, or updates
+// readup's existing comment for filename. If the file is up to date a
+// comment is only written when there's an earlier one to update.
+func githubReport(pr int, filename, body string, upToDate bool) error {
+	token := os.Getenv("GITHUB_TOKEN")
+	repo := os.Getenv("GITHUB_REPOSITORY")
+	if token == "" || repo == "" {
+		return fmt.Errorf("reporting to GitHub requires GITHUB_TOKEN and GITHUB_REPOSITORY (owner/name) to be set")
+	}
+
+	api := githubAPI()
+	existing, err := findGitHubComment(api, token, repo, pr, reportMarker(filename))
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]string{"body": body}
+	if existing != nil {
+		url := fmt.Sprintf("%s/repos/%s/issues/comments/%d", api, repo, existing.ID)
+		return githubRequest("PATCH", url, token, payload, nil)
+	}
+	if upToDate {
+		return nil
+	}
+
+	url := fmt.Sprintf("%s/repos/%s/issues/%d/comments", api, repo, pr)
+	return githubRequest("POST", url, token, payload, nil)
+}
+
+// githubAPI() returns the base URL of the GitHub API, which is
+// GITHUB_API_URL on GitHub Enterprise.
+func githubAPI() string {
+	api := os.Getenv("GITHUB_API_URL")
+	if api == "" {
+		api = defaultGitHubAPI
+	}
+	return strings.TrimSuffix(api, "/")
+}
+
+// findGitHubComment() returns the comment on pr containing marker, if any.
+func findGitHubComment(api, token, repo string, pr int, marker string) (*githubComment, error) {
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s/repos/%s/issues/%d/comments?per_page=100&page=%d", api, repo, pr, page)
+
+		var comments []githubComment
+		if err := githubRequest("GET", url, token, nil, &comments); err != nil {
+			return nil, err
+		}
+		if len(comments) == 0 {
+			return nil, nil
+		}
+
+		for i := range comments {
+			if strings.Contains(comments[i].Body, marker) {
+				return &comments[i], nil
+			}
+		}
+	}
+}
+
+// githubRequest() sends payload (if not nil) as JSON and decodes the
+// response into result (if not nil), or reads it as text if result is a
+// *string.
+func githubRequest(method, url, token string, payload, result interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("GitHub API %s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if text, ok := result.(*string); ok {
+		data, err := io.ReadAll(resp.Body)
+		*text = string(data)
+		return err
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package readup
+
+import (
+	"regexp"
+	"strings"
+)
+
+// With --highlight (or highlight: true in the config), output echoed to
+// the terminal is highlighted by the language the block declares for it,
+// so a long JSON response or YAML manifest is easier to scan as it
+// scrolls by:
+//
+//	```json
+//	> curl -s https://api.example.com/status
+//	```
+//
+// The language is a command block's own, or for a script block that of
+// the block its output goes in, and a block can choose another with the
+// highlight attribute, or turn highlighting off with highlight=none.
+// Highlighting works a line at a time, since output is echoed as it's
+// produced, and only changes what's shown: the document gets the output
+// as it was. The colors are the palette's key, string, number and
+// literal roles, and its added and removed colors for diffs.
+
+// highlighters highlight a line of output in each language.
+var highlighters = map[string]func(string) string{
+	"json":  highlightJSON,
+	"yaml":  highlightYAML,
+	"yml":   highlightYAML,
+	"diff":  highlightDiff,
+	"patch": highlightDiff,
+}
+
+// highlightLanguages are the languages highlighters know, for messages.
+var highlightLanguages = []string{"diff", "json", "patch", "yaml", "yml"}
+
+// highlightLanguage() returns the language to highlight the output of a
+// block declared in lang with, or "" for none.
+func highlightLanguage(lang string, attrs blockAttrs, opts *options) string {
+	if !opts.highlight {
+		return ""
+	}
+	lang = strings.ToLower(attrs.string("highlight", lang))
+	if _, ok := highlighters[lang]; !ok {
+		return ""
+	}
+	return lang
+}
+
+// highlightOutput() returns s highlighted as lang and indented like
+// greyFormat(), or just greyFormat(s) if lang is "".
+func highlightOutput(s, lang string) string {
+	highlight := highlighters[lang]
+	if highlight == nil {
+		return greyFormat(s)
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = outputIndent + highlight(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var (
+	jsonToken    = regexp.MustCompile(`"(?:[^"\\]|\\.)*"(\s*:)?|-?\b\d+(?:\.\d+)?(?:[eE][+-]?\d+)?\b|\b(?:true|false|null)\b`)
+	yamlKey      = regexp.MustCompile(`^(\s*(?:- +)?)([^\s#'"{\[][^:#]*|"[^"]*"|'[^']*')(:)(\s|$)`)
+	yamlNumber   = regexp.MustCompile(`^-?\d+(?:\.\d+)?(?:[eE][+-]?\d+)?$`)
+	yamlLiterals = []string{"true", "false", "null", "~", "yes", "no"}
+)
+
+// highlightJSON() highlights a line of JSON.
+func highlightJSON(line string) string {
+	return jsonToken.ReplaceAllStringFunc(line, func(token string) string {
+		switch {
+		case strings.HasPrefix(token, `"`):
+			if key := strings.TrimRight(token, " \t:"); key != token {
+				return paint(colors.key, key) + token[len(key):]
+			}
+			return paint(colors.string, token)
+		case token == "true" || token == "false" || token == "null":
+			return paint(colors.literal, token)
+		default:
+			return paint(colors.number, token)
+		}
+	})
+}
+
+// highlightYAML() highlights a line of YAML: keys, and scalar values
+// after them or after a list item's dash, with comments in the output
+// color.
+func highlightYAML(line string) string {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return paint(colors.output, line)
+	}
+
+	prefix, rest := "", line
+	if m := yamlKey.FindStringSubmatchIndex(line); m != nil {
+		prefix = line[:m[4]] + paint(colors.key, line[m[4]:m[5]]) + line[m[5]:m[1]]
+		rest = line[m[1]:]
+	} else if trimmed := strings.TrimLeft(line, " "); strings.HasPrefix(trimmed, "- ") {
+		n := len(line) - len(trimmed) + 2
+		prefix, rest = line[:n], line[n:]
+	}
+
+	value, comment := rest, ""
+	if i := strings.Index(rest, " #"); i >= 0 {
+		value, comment = rest[:i], rest[i:]
+	}
+	trimmed := strings.TrimSpace(value)
+	switch {
+	case trimmed == "":
+	case strings.HasPrefix(trimmed, `"`) || strings.HasPrefix(trimmed, "'"):
+		value = paint(colors.string, value)
+	case yamlNumber.MatchString(trimmed):
+		value = paint(colors.number, value)
+	case contains(yamlLiterals, strings.ToLower(trimmed)):
+		value = paint(colors.literal, value)
+	}
+	if comment != "" {
+		comment = paint(colors.output, comment)
+	}
+	return prefix + value + comment
+}
+
+// highlightDiff() highlights a line of a unified diff.
+func highlightDiff(line string) string {
+	switch {
+	case strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---"):
+		return paint(colors.heading, line)
+	case strings.HasPrefix(line, "@@"):
+		return paint(colors.key, line)
+	case strings.HasPrefix(line, "+"):
+		return paint(colors.added, line)
+	case strings.HasPrefix(line, "-"):
+		return paint(colors.removed, line)
+	}
+	return line
+}
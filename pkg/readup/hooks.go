@@ -0,0 +1,106 @@
+package readup
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Hooks are shell commands from the config run around each block, e.g.
+// to snapshot a test database before an example that changes it and
+// restore it after, and around each write of a file. They're told about
+// the block or file in READUP_* variables:
+//
+//	READUP_FILE       the file being run or written
+//	READUP_LINE       the line of the block's opening fence
+//	READUP_ID         the block's id attribute, if it has one
+//	READUP_COMMAND    the block's command, or script
+//	READUP_EXIT_CODE  after a block, its command's exit status
+//	READUP_STALE      after a block, 1 if its output changed and 0 if not
+//
+// A hook runs in /bin/sh, or cmd.exe on Windows. A hook that fails stops
+// the run. An after_block hook runs even if the block failed, without the
+// last two variables. As a library, readup calls Options.BeforeBlock and
+// Options.AfterBlock too.
+
+// hooksConfig are the hooks in the config.
+type hooksConfig struct {
+	BeforeBlock string `yaml:"before_block"`
+	AfterBlock  string `yaml:"after_block"`
+	BeforeWrite string `yaml:"before_write"`
+	AfterWrite  string `yaml:"after_write"`
+}
+
+// writeHooks are the hooks writeFile() runs.
+var writeHooks hooksConfig
+
+// runHook() runs the hook name's command, if it has one, with env added
+// to readup's environment.
+func runHook(name, command string, env []string) error {
+	if command == "" {
+		return nil
+	}
+	cmd := shellCommand(hookShell, command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+	return nil
+}
+
+// blockHookEnv() returns the READUP_* variables for a hook run around
+// block, and after it the results of running it.
+func blockHookEnv(filename string, block *codeBlock, results []*blockResult) []string {
+	env := []string{
+		"READUP_FILE=" + filename,
+		"READUP_LINE=" + strconv.Itoa(block.line),
+		"READUP_ID=" + block.attrs["id"],
+		"READUP_COMMAND=" + blockSource(block),
+	}
+	if len(results) > 0 {
+		stale := "0"
+		for _, result := range results {
+			if result.stale() {
+				stale = "1"
+			}
+		}
+		env = append(env,
+			"READUP_EXIT_CODE="+strconv.Itoa(results[len(results)-1].exitCode),
+			"READUP_STALE="+stale)
+	}
+	return env
+}
+
+// blockHooks() returns the functions options.beforeBlock and
+// options.afterBlock, which run the config's hooks and then the
+// library's callbacks, or nil for each if there's nothing to run.
+func blockHooks(hooks hooksConfig, o Options) (func(string, *codeBlock) error, func(string, *codeBlock, []*blockResult) error) {
+	var before func(string, *codeBlock) error
+	if hooks.BeforeBlock != "" || o.BeforeBlock != nil {
+		before = func(filename string, block *codeBlock) error {
+			if err := runHook("before_block", hooks.BeforeBlock, blockHookEnv(filename, block, nil)); err != nil {
+				return err
+			}
+			if o.BeforeBlock != nil {
+				return o.BeforeBlock(filename, &Block{block: block})
+			}
+			return nil
+		}
+	}
+
+	var after func(string, *codeBlock, []*blockResult) error
+	if hooks.AfterBlock != "" || o.AfterBlock != nil {
+		after = func(filename string, block *codeBlock, results []*blockResult) error {
+			if err := runHook("after_block", hooks.AfterBlock, blockHookEnv(filename, block, results)); err != nil {
+				return err
+			}
+			if o.AfterBlock != nil {
+				return o.AfterBlock(filename, &Block{block: block}, exportResults(results))
+			}
+			return nil
+		}
+	}
+	return before, after
+}
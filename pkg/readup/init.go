@@ -0,0 +1,103 @@
+package readup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// `readup init` sets a project up to use readup: it writes a starter
+// config, and offers to add an example block to the README and to
+// install a git pre-commit hook that runs `readup check`.
+
+const starterConfig = `# readup config, see https://github.com/bakks/readup
+
+# Output normalizers that blocks opt into with normalize=<name>, on top of
+# the builtin ones (uuid, timestamp, duration, tmppath, pid, ip).
+# normalizers:
+#   version:
+#     pattern: 'v\d+\.\d+\.\d+'
+#     replacement: 'vX.Y.Z'
+
+# Block commands run with a fixed locale and timezone so their output
+# doesn't depend on who ran readup.
+# locale: C.UTF-8
+# timezone: UTC
+
+# The terminal block commands run in.
+# columns: 80
+# lines: 40
+
+# Kill a block's command if it writes more than this.
+# max_output: 1M
+`
+
+const exampleBlock = "\n```\n> echo \"Hello from readup\"\n```\n"
+
+const preCommitHook = `#!/bin/sh
+# installed by readup init: fail the commit if %[1]s is out of date
+exec readup check %[1]s
+`
+
+// stdin is shared by every prompt, so none loses input buffered by
+// another.
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirm() asks the user a yes or no question, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s ", paint(colors.prompt, question+" [y/N]"))
+	text, _ := stdin.ReadString('\n')
+	return strings.ToLower(strings.TrimSpace(text)) == "y"
+}
+
+// initProject() sets up readup for the README filename, using the config
+// file at configFile.
+func initProject(filename, configFile string) error {
+	if _, err := os.Stat(configFile); err == nil {
+		fmt.Printf("%s already exists, leaving it alone\n", configFile)
+	} else if errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(configFile, []byte(starterConfig), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", configFile)
+	} else {
+		return err
+	}
+
+	if confirm(fmt.Sprintf("Add an example block to %s?", filename)) {
+		file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = file.WriteString(exampleBlock)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Added an example block to %s, run readup to fill it in\n", filename)
+	}
+
+	if confirm("Install a git pre-commit hook that runs readup check?") {
+		hooks, err := git(".", "rev-parse", "--git-path", "hooks")
+		if err != nil {
+			return err
+		}
+		hook := filepath.Join(strings.TrimSpace(hooks), "pre-commit")
+		if _, err := os.Stat(hook); err == nil {
+			return fmt.Errorf("%s already exists, add `readup check %s` to it yourself", hook, filename)
+		}
+		if err := os.MkdirAll(filepath.Dir(hook), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(hook, []byte(fmt.Sprintf(preCommitHook, filename)), 0755); err != nil {
+			return err
+		}
+		fmt.Printf("Installed %s\n", hook)
+	}
+	return nil
+}
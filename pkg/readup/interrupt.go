@@ -0,0 +1,119 @@
+package readup
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Block commands run in their own session or process group, so Ctrl-C
+// in the terminal only reaches readup. When it's interrupted readup
+// kills the running command and removes its temp files before exiting,
+// and the file being updated is only ever written whole, as is a set of
+// files updated together. Temp files are also removed when readup exits
+// any other way, with exit().
+
+// interrupts is what needs cleaning up if readup is interrupted.
+var interrupts struct {
+	sync.Mutex
+	// processes are the running commands, several if documents are run
+	// in parallel
+	processes []*os.Process
+	// tempFiles are the temp files that haven't been removed yet
+	tempFiles []string
+	// restore holds the previous contents of the files being written by
+	// writeFiles(), to put back if it's interrupted part way through
+	restore map[string]string
+}
+
+// handleInterrupts() starts cleaning up and exiting on SIGINT or
+// SIGTERM. A file that's being written is finished first, because
+// writing holds the interrupts lock.
+func handleInterrupts() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		interrupts.Lock()
+		for _, process := range interrupts.processes {
+			killProcessGroup(process)
+		}
+		removeTempFiles()
+		for name, content := range interrupts.restore {
+			os.WriteFile(name, []byte(content), 0644)
+		}
+
+		// reset any color a command's streamed output was left in
+		fmt.Print("\x1b[0m\n")
+		fmt.Fprintf(os.Stderr, "Interrupted (%s), no files were changed\n", sig)
+		os.Exit(130)
+	}()
+}
+
+// addRunning() records a command that's running.
+func addRunning(process *os.Process) {
+	interrupts.Lock()
+	defer interrupts.Unlock()
+	interrupts.processes = append(interrupts.processes, process)
+}
+
+// removeRunning() forgets a command added with addRunning() once it's
+// finished.
+func removeRunning(process *os.Process) {
+	interrupts.Lock()
+	defer interrupts.Unlock()
+	for i, running := range interrupts.processes {
+		if running == process {
+			interrupts.processes = append(interrupts.processes[:i], interrupts.processes[i+1:]...)
+			return
+		}
+	}
+}
+
+// addTempFile() records a temp file to remove if readup is interrupted.
+func addTempFile(name string) {
+	interrupts.Lock()
+	defer interrupts.Unlock()
+	interrupts.tempFiles = append(interrupts.tempFiles, name)
+}
+
+// removeTempFile() removes a temp file added with addTempFile(), unless
+// it's been kept with keepTempFile().
+func removeTempFile(name string) error {
+	if !keepTempFile(name) {
+		return nil
+	}
+	return os.Remove(name)
+}
+
+// keepTempFile() stops a temp file added with addTempFile() from being
+// removed, and reports whether it was still going to be.
+func keepTempFile(name string) bool {
+	interrupts.Lock()
+	defer interrupts.Unlock()
+	for i, tempFile := range interrupts.tempFiles {
+		if tempFile == name {
+			interrupts.tempFiles = append(interrupts.tempFiles[:i], interrupts.tempFiles[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// exit() removes any temp files that are left and exits with code.
+func exit(code int) {
+	interrupts.Lock()
+	removeTempFiles()
+	os.Exit(code)
+}
+
+// removeTempFiles() removes every temp file that's left. The caller
+// holds the interrupts lock.
+func removeTempFiles() {
+	for _, name := range interrupts.tempFiles {
+		os.Remove(name)
+	}
+	interrupts.tempFiles = nil
+}
@@ -0,0 +1,113 @@
+package readup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Configs are layered, so a subproject can change a few settings
+// without copying the whole config. readup reads the config in a
+// document's directory and in each of its parents, up to one that sets
+// `root: true` or the top of the filesystem, and layers the nearer ones
+// over those further up:
+//
+//   - a mapping, like normalizers, wrappers or hooks, is merged key by
+//     key, with the nearer config's value winning for a key both set
+//   - anything else, a string, number, flag or list, set in the nearer
+//     config replaces the value from further up
+//
+// So a package's `.readup.yaml` holding just `shell: /bin/zsh` runs its
+// README with zsh and otherwise the root config's settings. Paths in
+// path_prepend are relative to the config that lists them, and the
+// workspace section to the root config. Given --config, readup reads
+// only that file.
+
+// readConfigLayer() reads the config file filename, checking it and
+// making its relative paths absolute, and returns its top-level mapping
+// or nil if it's empty.
+func readConfigLayer(filename string) (*yaml.Node, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConfig(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
+		return nil, nil
+	}
+	node := root.Content[0]
+
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	if paths := mappingValue(node, "path_prepend"); paths != nil {
+		for _, path := range paths.Content {
+			if path.Kind == yaml.ScalarNode && !filepath.IsAbs(path.Value) {
+				path.Value = filepath.Join(filepath.Dir(abs), path.Value)
+			}
+		}
+	}
+	return node, nil
+}
+
+// isRootConfig() reports whether the config node sets root: true.
+func isRootConfig(node *yaml.Node) bool {
+	root := mappingValue(node, "root")
+	return root != nil && root.Tag == "!!bool" && root.Value == "true"
+}
+
+// mappingValue() returns the value of key in the mapping node, or nil
+// if it's not set.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mergeConfigNodes() returns the config node override layered over
+// base.
+func mergeConfigNodes(base, override *yaml.Node) *yaml.Node {
+	if base == nil {
+		return override
+	}
+	if override == nil {
+		return base
+	}
+	if base.Kind != yaml.MappingNode || override.Kind != yaml.MappingNode {
+		return override
+	}
+
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: base.Tag, Line: base.Line, Column: base.Column}
+	merged.Content = append(merged.Content, base.Content...)
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		key, value := override.Content[i], override.Content[i+1]
+		replaced := false
+		for j := 0; j+1 < len(merged.Content); j += 2 {
+			if merged.Content[j].Value == key.Value {
+				merged.Content[j+1] = mergeConfigNodes(merged.Content[j+1], value)
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged.Content = append(merged.Content, key, value)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,143 @@
+package readup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// `readup lint` checks a README's blocks for mistakes without running
+// them: unknown or invalid attributes, duplicate ids, unclosed fences
+// and commands that refer to files that don't exist.
+
+// lintProblem is a mistake found by lint, at a line of the file.
+type lintProblem struct {
+	line    int
+	message string
+}
+
+// lintFile() returns the problems found in filename.
+func lintFile(filename string) ([]lintProblem, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []lintProblem
+	report := func(line int, format string, args ...interface{}) {
+		problems = append(problems, lintProblem{line, fmt.Sprintf(format, args...)})
+	}
+
+	ids := map[string]int{}
+	for _, n := range parseDocument(data).nodes {
+		block := n.block
+		if block == nil {
+			continue
+		}
+		if block.closing == "" {
+			report(block.line, "code block isn't closed")
+		}
+		if id := block.attrs["id"]; id != "" {
+			if first, ok := ids[id]; ok {
+				report(block.line, "id %q is already used by the block at line %d", id, first)
+			} else {
+				ids[id] = block.line
+			}
+		}
+
+		// attributes on other blocks are none of readup's business
+		if !block.isCommand() && block.attrs["readup"] == "" {
+			continue
+		}
+		for _, problem := range lintAttrs(block.attrs) {
+			report(block.line, "%s", problem)
+		}
+
+		if block.isCommand() {
+			command, _, err := parseCommand(block.commandLines())
+			if err != nil {
+				report(block.line, "%s", err)
+				continue
+			}
+			for _, path := range missingPaths(command, filepath.Dir(filename)) {
+				report(block.line, "command refers to %s, which doesn't exist", path)
+			}
+		}
+	}
+	return problems, nil
+}
+
+// lintAttrs() returns what's wrong with a managed block's attributes.
+func lintAttrs(attrs blockAttrs) []string {
+	var problems []string
+	for key, value := range attrs {
+		if !contains(blockAttrNames, key) {
+			problems = append(problems, fmt.Sprintf("unknown attribute %q", key))
+			continue
+		}
+
+		switch key {
+		case "benchmark", "columns", "lines", "wrap", "expand-tabs":
+			if _, err := attrs.int(key, 0); err != nil {
+				problems = append(problems, err.Error())
+			}
+		case "capture":
+			if !contains(captureModes, value) {
+				problems = append(problems, fmt.Sprintf("unknown capture mode %q (available: %s)", value, strings.Join(captureModes, ", ")))
+			}
+		case "echo":
+			if !contains(echoStyles, value) {
+				problems = append(problems, fmt.Sprintf("unknown echo style %q (available: %s)", value, strings.Join(echoStyles, ", ")))
+			}
+		case "compare":
+			if _, ok := comparators[value]; !ok {
+				problems = append(problems, fmt.Sprintf("unknown comparator %q (available: %s)", value, strings.Join(comparatorNames, ", ")))
+			}
+		case "tolerance":
+			if _, _, err := parseTolerance(value); err != nil {
+				problems = append(problems, err.Error())
+			}
+		case "highlight":
+			if _, ok := highlighters[value]; !ok && value != "none" {
+				problems = append(problems, fmt.Sprintf("unknown highlight language %q (available: %s, none)", value, strings.Join(highlightLanguages, ", ")))
+			}
+		case "umask":
+			if !umaskPattern.MatchString(value) {
+				problems = append(problems, fmt.Sprintf("umask %q must be octal, e.g. 022", value))
+			}
+		case "readup":
+			if value != "script" && value != "output" {
+				problems = append(problems, fmt.Sprintf("readup=%s must be script or output", value))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// missingPaths() returns the words of command that look like paths,
+// starting with / or ./ or ../, but don't exist, with relative paths
+// taken from dir, where the command runs.
+func missingPaths(command, dir string) []string {
+	var missing []string
+	for _, word := range strings.Fields(command) {
+		word = strings.Trim(word, `'"`)
+		if !(strings.HasPrefix(word, "/") || strings.HasPrefix(word, "./") || strings.HasPrefix(word, "../")) {
+			continue
+		}
+		// leave anything the shell expands alone
+		if strings.ContainsAny(word, "$*?[{~`;|&<>()") {
+			continue
+		}
+		path := word
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			missing = append(missing, word)
+		}
+	}
+	return missing
+}
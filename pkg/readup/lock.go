@@ -0,0 +1,62 @@
+package readup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lockSuffix is added to a file's name for the lock file that stops two
+// readups updating it at once, e.g. an editor plugin and a manual run.
+const lockSuffix = ".readup.lock"
+
+// lockFile() takes the lock on filename, which is released when readup
+// exits the way temp files are removed. The lock file holds readup's
+// pid, so a lock left by a readup that was killed, whose process has
+// gone, is taken over rather than refused.
+func lockFile(filename string) error {
+	name := lockName(filename)
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		data, _ := os.ReadFile(name)
+		pid := strings.TrimSpace(string(data))
+		if n, parseErr := strconv.Atoi(pid); parseErr == nil && !processRunning(n) {
+			fmt.Fprintf(os.Stderr, "Warning: removing %s left by readup (pid %s), which isn't running\n", name, pid)
+			if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			file, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		}
+	}
+	if errors.Is(err, os.ErrExist) {
+		data, _ := os.ReadFile(name)
+		return fmt.Errorf("%s is being updated by another readup (pid %s), remove %s if it isn't running",
+			filename, strings.TrimSpace(string(data)), name)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	addTempFile(name)
+
+	_, err = fmt.Fprintf(file, "%d\n", os.Getpid())
+	return err
+}
+
+// unlockFile() releases the lock on filename taken with lockFile(), for
+// a process that goes on running after it's done with the file.
+func unlockFile(filename string) error {
+	return removeTempFile(lockName(filename))
+}
+
+// lockName() returns the name of filename's lock file.
+func lockName(filename string) string {
+	// runs on different symlinks to the file take the same lock
+	if resolved, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = resolved
+	}
+	return filename + lockSuffix
+}
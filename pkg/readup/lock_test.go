@@ -0,0 +1,49 @@
+package readup
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLockFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "README.md")
+	if err := lockFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if err := lockFile(filename); err == nil || !strings.Contains(err.Error(), "another readup") {
+		t.Errorf("taking the lock twice = %v, want an error", err)
+	}
+
+	if err := unlockFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if err := lockFile(filename); err != nil {
+		t.Errorf("taking the lock after unlocking = %v", err)
+	}
+	unlockFile(filename)
+}
+
+func TestLockFileStale(t *testing.T) {
+	// a pid that was just used, and so almost certainly isn't now
+	command := exec.Command("true")
+	if err := command.Run(); err != nil {
+		t.Skip(err)
+	}
+	filename := filepath.Join(t.TempDir(), "README.md")
+	if err := os.WriteFile(filename+lockSuffix, []byte(fmt.Sprintf("%d\n", command.Process.Pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lockFile(filename); err != nil {
+		t.Fatalf("taking a stale lock = %v", err)
+	}
+	defer removeTempFile(filename + lockSuffix)
+	data, _ := os.ReadFile(filename + lockSuffix)
+	if want := fmt.Sprintf("%d\n", os.Getpid()); string(data) != want {
+		t.Errorf("lock file holds %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,38 @@
+package readup
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// With --log-format=json readup writes an event to stderr for each step
+// of a run, one JSON object per line, for pipelines that collect logs.
+// The usual output on stdout is unchanged.
+
+var logFormats = []string{"text", "json"}
+
+// eventLog receives JSON log events, or is nil if they're off.
+var eventLog io.Writer
+
+// logEvent() logs the event, with fields describing it.
+func logEvent(event string, fields map[string]interface{}) {
+	if eventLog == nil {
+		return
+	}
+
+	entry := map[string]interface{}{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"event": event,
+	}
+	for k, v := range fields {
+		entry[k] = v
+	}
+
+	// a log line that can't be written isn't worth failing the run for
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	eventLog.Write(append(data, '\n'))
+}
@@ -0,0 +1,463 @@
+package readup
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+// `readup lsp` is a language server that editors run and talk to on
+// stdin and stdout. Saving a document runs its blocks and reports the
+// stale ones as diagnostics, and code actions refresh the block under
+// the cursor or the whole document, with progress shown while they
+// run. The blocks' own output goes to stderr, which editors log.
+
+const (
+	refreshBlockCommand = "readup.refreshBlock"
+	refreshAllCommand   = "readup.refreshAll"
+)
+
+// rpcMessage is a JSON-RPC 2.0 request, notification or response.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspCommand struct {
+	Title     string        `json:"title"`
+	Command   string        `json:"command"`
+	Arguments []interface{} `json:"arguments"`
+}
+
+type lspCodeAction struct {
+	Title   string     `json:"title"`
+	Kind    string     `json:"kind"`
+	Command lspCommand `json:"command"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+// lspServer is the state of a `readup lsp` session.
+type lspServer struct {
+	opts *options
+
+	// out is where messages to the editor go, and writing holds the lock
+	// so messages from different runs aren't interleaved
+	outMu sync.Mutex
+	out   io.Writer
+	// nextID numbers the requests sent to the editor
+	nextID int
+
+	// docs are the open documents' text, and stale the lines of the blocks
+	// in each found out of date when it was last saved
+	docsMu sync.Mutex
+	docs   map[string]string
+	stale  map[string]map[int]string
+
+	// running is held while blocks run, so one run finishes before the
+	// next starts, and progressTokens counts the runs that showed progress
+	running        sync.Mutex
+	progressTokens int
+	// workDoneProgress is set if the editor can show progress
+	workDoneProgress bool
+	shutdown         bool
+}
+
+// serveLSP() runs a language server reading messages from in and
+// writing them to out until the editor tells it to exit, and returns
+// the status to exit with.
+func serveLSP(in io.Reader, out io.Writer, opts *options) int {
+	s := &lspServer{
+		opts:  opts,
+		out:   out,
+		docs:  map[string]string{},
+		stale: map[string]map[int]string{},
+	}
+
+	reader := textproto.NewReader(bufio.NewReader(in))
+	for {
+		header, err := reader.ReadMIMEHeader()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			}
+			return 1
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: bad Content-Length %q\n", header.Get("Content-Length"))
+			return 1
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader.R, body); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			return 1
+		}
+
+		var msg rpcMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			s.respond(nil, nil, &rpcError{Code: rpcParseError, Message: err.Error()})
+			continue
+		}
+		if msg.Method == "exit" {
+			if s.shutdown {
+				return 0
+			}
+			return 1
+		}
+		s.handle(&msg)
+	}
+}
+
+// handle() handles a message from the editor. Responses to the
+// server's own requests are ignored.
+func (s *lspServer) handle(msg *rpcMessage) {
+	var result interface{}
+	var rpcErr *rpcError
+
+	switch msg.Method {
+	case "":
+		return
+	case "initialize":
+		var params struct {
+			Capabilities struct {
+				Window struct {
+					WorkDoneProgress bool `json:"workDoneProgress"`
+				} `json:"window"`
+			} `json:"capabilities"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		s.workDoneProgress = params.Capabilities.Window.WorkDoneProgress
+
+		v, _, _ := buildInfo()
+		result = map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": map[string]interface{}{
+					"openClose": true,
+					// the whole document is sent on every change
+					"change": 1,
+					"save":   true,
+				},
+				"codeActionProvider": true,
+				"executeCommandProvider": map[string]interface{}{
+					"commands": []string{refreshBlockCommand, refreshAllCommand},
+				},
+			},
+			"serverInfo": map[string]string{"name": "readup", "version": v},
+		}
+	case "shutdown":
+		s.shutdown = true
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err == nil {
+			s.setDoc(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument   lspTextDocument `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err == nil && len(params.ContentChanges) > 0 {
+			s.setDoc(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+	case "textDocument/didSave":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err == nil {
+			go s.refresh(params.TextDocument.URI, 0, false)
+		}
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err == nil {
+			s.docsMu.Lock()
+			delete(s.docs, params.TextDocument.URI)
+			delete(s.stale, params.TextDocument.URI)
+			s.docsMu.Unlock()
+			s.publishDiagnostics(params.TextDocument.URI)
+		}
+	case "textDocument/codeAction":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+			Range        lspRange        `json:"range"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			rpcErr = &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+			break
+		}
+		result = s.codeActions(params.TextDocument.URI, params.Range.Start.Line+1)
+	case "workspace/executeCommand":
+		var params struct {
+			Command   string            `json:"command"`
+			Arguments []json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil || len(params.Arguments) == 0 {
+			rpcErr = &rpcError{Code: rpcInvalidParams, Message: "expected a document uri"}
+			break
+		}
+		var uri string
+		line := 0
+		json.Unmarshal(params.Arguments[0], &uri)
+		if params.Command == refreshBlockCommand && len(params.Arguments) > 1 {
+			json.Unmarshal(params.Arguments[1], &line)
+		}
+		go s.refresh(uri, line, true)
+	default:
+		if msg.ID == nil {
+			// notifications the server doesn't need are ignored
+			return
+		}
+		rpcErr = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("method %q not supported", msg.Method)}
+	}
+
+	if msg.ID != nil {
+		s.respond(msg.ID, result, rpcErr)
+	}
+}
+
+func (s *lspServer) setDoc(uri, text string) {
+	s.docsMu.Lock()
+	defer s.docsMu.Unlock()
+	s.docs[uri] = text
+}
+
+// codeActions() returns the actions for the block at line of the
+// document at uri, if there's one there, and for the whole document.
+func (s *lspServer) codeActions(uri string, line int) []lspCodeAction {
+	s.docsMu.Lock()
+	text, ok := s.docs[uri]
+	s.docsMu.Unlock()
+	actions := []lspCodeAction{}
+	if !ok {
+		return actions
+	}
+
+	for _, n := range parseDocument([]byte(text)).nodes {
+		if shouldRun(n.block, s.opts) && n.block.line <= line && line <= n.block.lastLine() {
+			actions = append(actions, lspCodeAction{
+				Title:   "Refresh block",
+				Kind:    "quickfix",
+				Command: lspCommand{Title: "Refresh block", Command: refreshBlockCommand, Arguments: []interface{}{uri, n.block.line}},
+			})
+			break
+		}
+	}
+	return append(actions, lspCodeAction{
+		Title:   "Refresh all blocks",
+		Kind:    "source",
+		Command: lspCommand{Title: "Refresh all blocks", Command: refreshAllCommand, Arguments: []interface{}{uri}},
+	})
+}
+
+// refresh() runs the blocks of the document at uri, or just the one at
+// line if it's not 0. If apply is set the document is edited to match,
+// otherwise stale blocks are reported as diagnostics.
+func (s *lspServer) refresh(uri string, line int, apply bool) {
+	s.docsMu.Lock()
+	text, ok := s.docs[uri]
+	s.docsMu.Unlock()
+	if !ok {
+		return
+	}
+	filename := uriPath(uri)
+
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	opts := *s.opts
+	if line != 0 {
+		opts.changed = lineRanges{{line, line}}
+	}
+	token := ""
+	if s.workDoneProgress {
+		s.progressTokens++
+		token = fmt.Sprintf("readup-%d", s.progressTokens)
+		s.request("window/workDoneProgress/create", map[string]string{"token": token})
+		s.notify("$/progress", map[string]interface{}{
+			"token": token,
+			"value": map[string]interface{}{"kind": "begin", "title": "readup", "percentage": 0},
+		})
+		opts.progress = func(done, total, blockLine int) {
+			s.notify("$/progress", map[string]interface{}{
+				"token": token,
+				"value": map[string]interface{}{
+					"kind":       "report",
+					"message":    fmt.Sprintf("%d/%d: line %d", done, total, blockLine),
+					"percentage": 100 * (done - 1) / total,
+				},
+			})
+		}
+	}
+
+	content, results, err := runDocument(filename, []byte(text), &opts)
+	if token != "" {
+		s.notify("$/progress", map[string]interface{}{"token": token, "value": map[string]string{"kind": "end"}})
+	}
+	if err != nil {
+		// type 1 is an error
+		s.notify("window/showMessage", map[string]interface{}{"type": 1, "message": "readup: " + err.Error()})
+		return
+	}
+
+	s.docsMu.Lock()
+	stale := s.stale[uri]
+	if stale == nil || line == 0 {
+		stale = map[int]string{}
+	}
+	for _, result := range results {
+		delete(stale, result.line)
+		if result.stale() && !apply {
+			stale[result.line] = fmt.Sprintf("output of `%s` is out of date", result.command)
+		}
+	}
+	s.stale[uri] = stale
+	s.docsMu.Unlock()
+	s.publishDiagnostics(uri)
+
+	if apply && content != text {
+		lines := strings.Split(text, "\n")
+		end := lspPosition{Line: len(lines) - 1, Character: utf16Len(lines[len(lines)-1])}
+		s.request("workspace/applyEdit", map[string]interface{}{
+			"label": "readup",
+			"edit": map[string]interface{}{
+				"changes": map[string]interface{}{
+					uri: []map[string]interface{}{{"range": lspRange{End: end}, "newText": content}},
+				},
+			},
+		})
+	}
+}
+
+// publishDiagnostics() sends the stale blocks of the document at uri.
+func (s *lspServer) publishDiagnostics(uri string) {
+	s.docsMu.Lock()
+	lines := strings.Split(s.docs[uri], "\n")
+	diagnostics := []lspDiagnostic{}
+	for line, message := range s.stale[uri] {
+		width := 0
+		if line <= len(lines) {
+			width = utf16Len(lines[line-1])
+		}
+		diagnostics = append(diagnostics, lspDiagnostic{
+			Range: lspRange{
+				Start: lspPosition{Line: line - 1},
+				End:   lspPosition{Line: line - 1, Character: width},
+			},
+			// 2 is a warning
+			Severity: 2,
+			Source:   "readup",
+			Message:  message,
+		})
+	}
+	s.docsMu.Unlock()
+
+	s.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": diagnostics})
+}
+
+// send() writes msg to the editor.
+func (s *lspServer) send(msg *rpcMessage) {
+	msg.JSONRPC = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (s *lspServer) respond(id json.RawMessage, result interface{}, rpcErr *rpcError) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	msg := &rpcMessage{ID: id, Error: rpcErr}
+	if rpcErr == nil {
+		msg.Result, _ = json.Marshal(result)
+	}
+	s.send(msg)
+}
+
+func (s *lspServer) notify(method string, params interface{}) {
+	data, _ := json.Marshal(params)
+	s.send(&rpcMessage{Method: method, Params: data})
+}
+
+// request() sends a request to the editor and returns its id. The
+// response isn't waited for.
+func (s *lspServer) request(method string, params interface{}) int {
+	s.outMu.Lock()
+	s.nextID++
+	id := s.nextID
+	s.outMu.Unlock()
+
+	data, _ := json.Marshal(params)
+	s.send(&rpcMessage{ID: json.RawMessage(strconv.Itoa(id)), Method: method, Params: data})
+	return id
+}
+
+// uriPath() returns the path of a file:// uri, or the uri itself if
+// it's something else.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return u.Path
+}
+
+// utf16Len() returns the length of s in UTF-16 code units, which is how
+// LSP measures positions in a line.
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
@@ -0,0 +1,1150 @@
+package readup
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readup is a simple utility for keeping a README file up to date
+// with command output. Given a README file, it looks for code blocks
+// surrounded with '```' that start '> [command]' on the first line.
+// It then runs the command and replaces the code block with the
+// output of the command (except for the command itself).
+
+// options holds the settings for a single readup run.
+type options struct {
+	// normalizers are all the builtin and configured normalizers
+	normalizers []*normalizer
+	// normalize names the normalizers applied to every block, in
+	// addition to those a block selects with its normalize attribute
+	normalize []string
+	// baseEnv, if set, is the environment block commands start from
+	// instead of readup's own
+	baseEnv []string
+	// env is added to the environment of every block command
+	env []string
+	// record, if set, collects the output of every block
+	record *outputStore
+	// replay, if set, supplies block output instead of running commands
+	replay *outputStore
+	// offline, if set, supplies the output of blocks that can't be run
+	// offline, i.e. those marked network=true, or all of them if
+	// offlineAll is set
+	offline    *outputStore
+	offlineAll bool
+	// changed, if set, restricts running to blocks overlapping these
+	// lines of the file
+	changed lineRanges
+	// stamp writes a comment after each block recording when it was
+	// refreshed
+	stamp bool
+	// strictToolVersions fails blocks whose tool-version doesn't match,
+	// rather than just warning
+	strictToolVersions bool
+	// toolVersions caches the version found for each tool, and way of
+	// running it, see toolVersion()
+	toolVersions map[string]string
+	// maxOutput is the most output in bytes a block may produce, or 0
+	// for no limit
+	maxOutput int64
+	// term, columns and lines are the default terminal for blocks, see
+	// execOptions
+	term           string
+	columns, lines int
+	// capture is how block output is captured, see captureModes
+	capture string
+	// wrap soft-wraps output lines to this width if it's not 0
+	wrap int
+	// expandTabs expands tabs in output to tab stops this far apart if
+	// it's not 0
+	expandTabs int
+	// trimTrailingSpace and trimBlankLines tidy up the end of output
+	// lines and of the output as a whole
+	trimTrailingSpace bool
+	trimBlankLines    bool
+	// ignoreWhitespace counts output differing only in whitespace as
+	// unchanged, see compare.go
+	ignoreWhitespace bool
+	// ignoreANSI compares output without its escape sequences
+	ignoreANSI bool
+	// compare is the comparator for blocks that don't choose one
+	compare string
+	// interpreters map a language to the command that runs its scripts
+	interpreters map[string]string
+	// shell runs block commands, see execOptions
+	shell string
+	// containerEngine runs blocks with the container attribute
+	containerEngine string
+	// wrapper is the environment wrapper for blocks, and wrappers those
+	// known by name, see environmentWrapper()
+	wrapper  string
+	wrappers map[string]string
+	// toolManager pins the versions in a block's tools attribute, one
+	// of toolManagers
+	toolManager string
+	// kubeContext and kubeNamespace are where blocks with the pod
+	// attribute run, if not kubectl's defaults
+	kubeContext, kubeNamespace string
+	// echo is how commands are shown in blocks, one of echoStyles
+	echo string
+	// only, if set, restricts running to blocks with these ids, and
+	// match to blocks whose command matches it
+	only  []string
+	match *regexp.Regexp
+	// picked, if set, restricts running to the blocks starting on these
+	// lines, chosen with --pick
+	picked map[int]bool
+	// progress, if set, is told the line of each block as it starts, and
+	// how many of the run's blocks that makes
+	progress func(done, total, line int)
+	// beforeBlock and afterBlock, if set, are called around running each
+	// block, see hooks.go
+	beforeBlock func(filename string, block *codeBlock) error
+	afterBlock  func(filename string, block *codeBlock, results []*blockResult) error
+	// dir is the directory block commands run in, or "" for the current
+	// one
+	dir string
+	// quiet stops commands and their output being printed as they run,
+	// for documents run in parallel
+	quiet bool
+	// applySuccessful keeps going past a failed block, leaving it as it
+	// was and returning the document with the other blocks updated, and
+	// the failures as blockErrors
+	applySuccessful bool
+	// highlight highlights echoed output by the language of its block
+	highlight bool
+}
+
+// outputIndent is what each line of echoed output is indented with.
+var outputIndent = "  "
+
+// parseIndent() returns the indent the config's output_indent describes:
+// a number of spaces, "none", or the prefix itself, like "| ".
+func parseIndent(indent string) (string, error) {
+	if indent == "none" {
+		return "", nil
+	}
+	if n, err := strconv.Atoi(indent); err == nil {
+		if n < 0 {
+			return "", fmt.Errorf("output_indent can't be negative")
+		}
+		return strings.Repeat(" ", n), nil
+	}
+	return indent, nil
+}
+
+// Split s into lines, indent each line with outputIndent and color it
+// with the output color, grey by default.
+func greyFormat(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = outputIndent + paint(colors.output, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Read in a string which is the output of calling diff,
+// color every line that starts with '<' with the removed color (red by
+// default), and every line that starts with '>' with the added color.
+// Context lines whose line in the original, numbered by the hunk
+// headers, is in prose are rendered as Markdown, see prose.go.
+func diffFormat(s string, prose map[int]bool) string {
+	lines := strings.Split(s, "\n")
+	old := 0
+	for i, line := range lines {
+		if match := hunkPattern.FindStringSubmatch(line); match != nil {
+			old, _ = strconv.Atoi(match[1])
+			continue
+		}
+		if strings.HasPrefix(line, "<") || strings.HasPrefix(line, "-") {
+			lines[i] = paint(colors.removed, line)
+			old++
+		} else if strings.HasPrefix(line, ">") || strings.HasPrefix(line, "+") {
+			lines[i] = paint(colors.added, line)
+		} else if strings.HasPrefix(line, " ") {
+			if prose[old] {
+				lines[i] = " " + renderMarkdown(line[1:])
+			}
+			old++
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// readup() is the main function that reads the README file, finds
+// the code blocks, looks for a '> [command]' on the first line,
+// and if it finds it, executes the command and replaces the code
+// block with the output. It returns the updated file content and
+// a result for each block that was run, which on error are those run
+// before the failing block.
+func readup(filename string, opts *options) (string, []*blockResult, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	return runDocument(filename, data, opts)
+}
+
+// runDocument() is readup() for the contents of filename, which may not
+// have been saved yet.
+func runDocument(filename string, data []byte, opts *options) (string, []*blockResult, error) {
+	var err error
+	doc := parseDocument(data)
+
+	total := 0
+	for _, n := range doc.nodes {
+		if shouldRun(n.block, opts) {
+			total++
+		}
+	}
+	progress := newProgress(total)
+	progress.report = opts.progress
+	progress.quiet = opts.quiet
+	logEvent("parse", map[string]interface{}{"file": filename, "blocks": total})
+
+	var results []*blockResult
+	var nodes []*node
+	var failures blockErrors
+	for i := 0; i < len(doc.nodes); i++ {
+		n := doc.nodes[i]
+		nodes = append(nodes, n)
+
+		block := n.block
+		if !shouldRun(block, opts) {
+			continue
+		}
+		progress.next(filename, block.line)
+		if opts.beforeBlock != nil {
+			if err := opts.beforeBlock(filename, block); err != nil {
+				return "", results, fmt.Errorf("%s:%d: %w", filename, block.line, err)
+			}
+		}
+
+		// with applySuccessful a failed block is put back as it was
+		saved := block.clone()
+		var output, savedOutput *codeBlock
+
+		var blockResults []*blockResult
+		if block.attrs["readup"] == "script" {
+			// the output goes in the following output block, which is
+			// added if there isn't one yet
+			if i+1 < len(doc.nodes) && isScriptOutput(doc.nodes[i+1].block) {
+				output = doc.nodes[i+1].block
+				savedOutput = output.clone()
+				i++
+			} else {
+				output = &codeBlock{
+					fence:   block.prefix + scriptOutputFence,
+					closing: block.prefix + "```",
+					marker:  "```",
+					prefix:  block.prefix,
+				}
+			}
+			nodes = append(nodes, &node{block: output})
+
+			var result *blockResult
+			result, err = runScriptBlock(block, output, opts)
+			if result != nil {
+				blockResults = []*blockResult{result}
+			}
+		} else if block.attrs.bool("session", false) {
+			blockResults, err = runSessionBlock(block, opts)
+		} else {
+			var result *blockResult
+			result, err = runCommandBlock(block, opts)
+			if result != nil {
+				blockResults = []*blockResult{result}
+			}
+		}
+		for _, result := range blockResults {
+			result.line = block.line
+		}
+
+		// the after hook also runs for a failed block, to clean up after
+		// it
+		if opts.afterBlock != nil {
+			if hookErr := opts.afterBlock(filename, block, blockResults); err == nil {
+				err = hookErr
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("%s:%d: %w", filename, block.line, err)
+			if !opts.applySuccessful {
+				return "", append(results, blockResults...), err
+			}
+			failures = append(failures, err)
+			*block = *saved
+			if savedOutput != nil {
+				*output = *savedOutput
+			} else if output != nil {
+				// the output block was added for this run
+				nodes = nodes[:len(nodes)-1]
+			}
+			continue
+		}
+
+		stale := false
+		var tools []string
+		for _, result := range blockResults {
+			results = append(results, result)
+			logEvent("exec", map[string]interface{}{
+				"file":        filename,
+				"line":        result.line,
+				"command":     result.command,
+				"exit_code":   result.exitCode,
+				"duration_ms": float64(result.duration.Microseconds()) / 1000,
+				"stale":       result.stale(),
+			})
+			stale = stale || result.stale()
+			for _, tool := range result.tools {
+				if !contains(tools, tool) {
+					tools = append(tools, tool)
+				}
+			}
+		}
+
+		// a stamp on the line after the block is replaced if the output
+		// changed, and added if it's missing
+		if opts.stamp {
+			if i+1 < len(doc.nodes) && doc.nodes[i+1].block == nil && stampPattern.MatchString(doc.nodes[i+1].text) {
+				i++
+				if !stale {
+					nodes = append(nodes, doc.nodes[i])
+					continue
+				}
+			}
+			nodes = append(nodes, &node{text: blockStamp(time.Now(), tools)})
+		}
+	}
+	doc.nodes = nodes
+
+	if len(failures) > 0 {
+		return doc.render(), results, failures
+	}
+	return doc.render(), results, nil
+}
+
+// blockErrors are the failures of the blocks a run with applySuccessful
+// left as they were, while updating the rest.
+type blockErrors []error
+
+func (e blockErrors) Error() string {
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// shouldRun() reports whether block is a command or script block that
+// this run should refresh.
+func shouldRun(block *codeBlock, opts *options) bool {
+	if block == nil || !(block.isCommand() || block.attrs["readup"] == "script") {
+		return false
+	}
+	// with --since, leave blocks outside the changed lines alone
+	if opts.changed != nil && !opts.changed.overlaps(block.line, block.lastLine()) {
+		return false
+	}
+	if opts.only != nil && !contains(opts.only, block.attrs["id"]) {
+		return false
+	}
+	if opts.picked != nil && !opts.picked[block.line] {
+		return false
+	}
+	return opts.match == nil || opts.match.MatchString(blockSource(block))
+}
+
+// blockSource() returns a block's command, or a script block's script,
+// without running anything.
+func blockSource(block *codeBlock) string {
+	if block.attrs["readup"] == "script" {
+		return strings.Join(block.content(), "\n")
+	}
+	body := block.commandLines()
+	command, _, err := parseCommand(body)
+	if err != nil {
+		return strings.TrimPrefix(body[0], "> ")
+	}
+	return command
+}
+
+// isScriptOutput() reports whether block holds the output of a script.
+func isScriptOutput(block *codeBlock) bool {
+	return block != nil && block.attrs["readup"] == "output"
+}
+
+// checkWritable() returns an error suggesting alternatives if filename
+// can't be updated.
+func checkWritable(filename string) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("%s isn't writable, use --output <file> to write the updated file somewhere else, --output - to print it, or --patch to print a patch", filename)
+	}
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// replaceSymlinks makes writeFile() replace a symlink with a regular
+// file, rather than write to the file it links to.
+var replaceSymlinks bool
+
+// writeFile() writes content to filename, which an interrupt can't stop
+// part way through, running the write hooks around it.
+func writeFile(filename, content string) error {
+	env := []string{"READUP_FILE=" + filename}
+	if err := runHook("before_write", writeHooks.BeforeWrite, env); err != nil {
+		return err
+	}
+	if err := writeContent(filename, content); err != nil {
+		return err
+	}
+	return runHook("after_write", writeHooks.AfterWrite, env)
+}
+
+func writeContent(filename, content string) error {
+	interrupts.Lock()
+	defer interrupts.Unlock()
+
+	if replaceSymlinks {
+		if info, err := os.Lstat(filename); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(filename); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Write the lines back to the file
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s", content)
+	return err
+}
+
+// tempDir is where writeTempFile() writes, or "" for $TMPDIR or the
+// system's temp directory.
+var tempDir string
+
+func writeTempFile(filename, content string) (string, error) {
+	// Write the lines back to the file
+	file, err := ioutil.TempFile(tempDir, "readup")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	addTempFile(file.Name())
+
+	fmt.Fprintf(file, "%s", content)
+
+	return file.Name(), nil
+}
+
+// shellQuote() quotes s as a single word for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// firstString() returns the first of values that isn't empty, which is
+// used to let flags override the config.
+func firstString(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// firstInt() returns the first of values that isn't 0.
+func firstInt(values ...int) int {
+	for _, value := range values {
+		if value != 0 {
+			return value
+		}
+	}
+	return 0
+}
+
+// Main() runs the readup command with os.Args.
+func Main() {
+	// the shell completion scripts call this to list a file's blocks
+	if len(os.Args) > 1 && os.Args[1] == blockIDsCommand {
+		printBlockIDs(os.Args[2:])
+		os.Exit(0)
+	}
+	// `readup record` and `readup replay` work like a normal run, but
+	// save or reuse block output in a sidecar store
+	mode := "run"
+	args := os.Args[1:]
+	if len(args) > 0 && (contains(subcommandNames(), args[0]) || args[0] == manCommand) {
+		mode = args[0]
+		args = args[1:]
+	}
+
+	configFlag := flag.String("config", "",
+		fmt.Sprintf("config file to read (default %s if it exists)", defaultConfigFile))
+	normalizeFlag := flag.String("normalize", "",
+		fmt.Sprintf("comma-separated output normalizers to apply to every block (%s, or any defined in the config), or \"all\"",
+			strings.Join(normalizerNames(builtinNormalizers), ", ")))
+	deterministicFlag := flag.Bool("deterministic", false,
+		"set the config's deterministic_env variables (default SOURCE_DATE_EPOCH=0) for every block")
+	cleanEnvFlag := flag.Bool("clean-env", false,
+		"run block commands with only PATH, HOME and the config's env_passthrough variables rather than readup's whole environment")
+	pathPrependFlag := flag.String("path-prepend", "",
+		fmt.Sprintf("directories to put at the front of PATH for block commands, separated by %q, e.g. ./bin%c./node_modules/.bin", os.PathListSeparator, os.PathListSeparator))
+	storeFlag := flag.String("store", "",
+		"output store used by record and replay (default <file>.readup.json)")
+	offlineFlag := flag.Bool("offline", false,
+		"use recorded output for blocks marked network=true (or all blocks, see offline_scope) instead of running them")
+	diffBaseFlag := flag.String("diff-base", "",
+		"show the diff against the file as of this git revision (e.g. HEAD) instead of the working copy")
+	sinceFlag := flag.String("since", "",
+		"only run blocks whose lines changed since this git revision")
+	commitFlag := flag.String("commit", "",
+		"after updating the file, or files, commit them to git with this message")
+	checkFlag := flag.Bool("check", false,
+		"same as the check command")
+	allFlag := flag.Bool("all", false,
+		"run every document in the workspace, those listed in the config's workspace section or else every Markdown file under it")
+	includeFlag := flag.String("include", "",
+		"with --all or a directory, only run documents matching these comma-separated globs, e.g. docs/**")
+	excludeFlag := flag.String("exclude", "",
+		"with --all or a directory, don't run documents matching these comma-separated globs, e.g. examples/**")
+	noGitignoreFlag := flag.Bool("no-gitignore", false,
+		"with --all or a directory, also run documents that .gitignore ignores")
+	jobsFlag := flag.Int("jobs", -1,
+		"with --all or a directory, how many documents to run at once, 0 for one per CPU (default the config's workspace jobs, or 1)")
+	githubPRFlag := flag.Int("github-pr", 0,
+		"with --check, post or update a comment on this GitHub pull request summarizing stale blocks (uses GITHUB_TOKEN and GITHUB_REPOSITORY)")
+	reportFlag := flag.String("report", "",
+		"write a report with a test case for each block to this file")
+	reportFormatFlag := flag.String("report-format", "junit",
+		fmt.Sprintf("format of --report (%s)", strings.Join(reportFormats, ", ")))
+	stampFlag := flag.Bool("stamp", false,
+		"write a comment after each block recording when its output last changed")
+	strictToolVersionsFlag := flag.Bool("strict-tool-versions", false,
+		"fail, rather than warn, when a block's tool-version doesn't match the installed tool")
+	maxOutputFlag := flag.String("max-output", "",
+		fmt.Sprintf("kill a block's command if it produces more output than this, e.g. 500K or 10M, 0 for no limit (default %s)", defaultMaxOutput))
+	shellFlag := flag.String("shell", "",
+		fmt.Sprintf("shell that runs block commands with -c, e.g. /bin/zsh (default %s)", defaultShell))
+	wrapperFlag := flag.String("wrapper", "",
+		"run block commands inside a wrapper setting up the project's environment, by name (nix, devbox, or one from the config) or as a command like \"nix develop -c\"")
+	termFlag := flag.String("term", "",
+		"TERM for block commands (default inherited)")
+	columnsFlag := flag.Int("columns", 0,
+		fmt.Sprintf("terminal width for block commands, also sets COLUMNS (default %d)", defaultColumns))
+	linesFlag := flag.Int("lines", 0,
+		fmt.Sprintf("terminal height for block commands, also sets LINES (default %d)", defaultLines))
+	captureFlag := flag.String("capture", "",
+		fmt.Sprintf("how to capture block output (%s) (default %s)", strings.Join(captureModes, ", "), defaultCapture))
+	wrapFlag := flag.Int("wrap", 0,
+		"soft-wrap block output lines longer than this many columns")
+	expandTabsFlag := flag.Int("expand-tabs", 0,
+		"expand tabs in block output to spaces, with tab stops this many columns apart")
+	trimTrailingSpaceFlag := flag.Bool("trim-trailing-space", false,
+		"strip trailing whitespace from block output lines")
+	trimBlankLinesFlag := flag.Bool("trim-blank-lines", false,
+		"drop blank lines from the end of block output")
+	ignoreWhitespaceFlag := flag.Bool("ignore-whitespace", false,
+		"count block output that only differs in trailing whitespace or blank lines as unchanged, leaving the block as it is")
+	ignoreANSIFlag := flag.Bool("ignore-ansi", false,
+		"compare block output without its ANSI escape sequences, so output only differing in colors counts as unchanged")
+	timingsFlag := flag.Bool("timings", false,
+		"print how long each block took, slowest first")
+	onlyFlag := flag.String("only", "",
+		"only run blocks with these comma-separated ids, set with a block's id attribute")
+	matchFlag := flag.String("match", "",
+		"only run blocks whose command matches this regular expression")
+	pickFlag := flag.Bool("pick", false,
+		"choose which blocks to run from a checklist, or by number if stdin isn't a terminal, before running any")
+	metricsFlag := flag.String("metrics", "",
+		"write a JSON summary of the run (blocks run, cache hits, stale blocks, failures, duration) to this file")
+	tmpdirFlag := flag.String("tmpdir", "",
+		"directory to write intermediate files in (default $TMPDIR, or the system's temp directory)")
+	applySuccessfulFlag := flag.Bool("apply-successful", false,
+		"if some blocks fail, still update the blocks that ran cleanly, leaving the failed ones as they were (readup still exits with status 1)")
+	highlightFlag := flag.Bool("highlight", false,
+		"highlight the output of blocks as they run by the block's language (json, yaml, diff)")
+	patchFlag := flag.Bool("patch", false,
+		"print the diff to stdout as a patch, uncolored, and don't update the file, with readup's own output on stderr")
+	diffContextFlag := flag.Int("diff-context", -1,
+		"how many lines of context to show around each change in diffs and patches, like diff -U (default the config's diff_context, or 3)")
+	diffStyleFlag := flag.String("diff-style", "",
+		fmt.Sprintf("how to show changes (%s): one diff of the file, a diff of each changed block under its command, or both (default file)", strings.Join(diffStyles, ", ")))
+	diffOutputFlag := flag.String("diff-output", "",
+		"also write the diff, uncolored, to this file as a patch that git apply can apply")
+	outputFlag := flag.String("output", "",
+		"write the updated file here, or to stdout if it's -, instead of updating the file")
+	replaceSymlinkFlag := flag.Bool("replace-symlink", false,
+		"if the file is a symlink, replace it with the updated file rather than updating the file it links to")
+	keepTempFlag := flag.Bool("keep-temp", false,
+		"keep the temp file with the content readup would write, and print its path")
+	listenFlag := flag.String("listen", defaultListen,
+		"address readup serve and preview listen on")
+	uiFlag := flag.Bool("ui", false,
+		"with serve, also serve a page for reviewing the changes to each block and accepting or rejecting them")
+	githubMarkdownFlag := flag.Bool("github-markdown", false,
+		"with preview, render the file with GitHub's Markdown API, which sends it to GitHub, rather than locally")
+	forceFlag := flag.Bool("force", false,
+		"with self-update, install the latest release even if it isn't newer or this isn't a release build")
+	notifyFlag := flag.Bool("notify", false,
+		"show a desktop notification when the run finishes or fails (the config's notify section can also call a webhook)")
+	logFormatFlag := flag.String("log-format", "text",
+		fmt.Sprintf("format of log events (%s), json writes one event per step to stderr", strings.Join(logFormats, ", ")))
+	flag.Usage = usage
+	flag.CommandLine.Parse(args)
+
+	if mode == manCommand {
+		fmt.Print(manPage())
+		os.Exit(0)
+	}
+
+	if mode == "version" {
+		printVersion()
+		os.Exit(0)
+	}
+
+	if mode == "self-update" {
+		if err := selfUpdate(*forceFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if mode == "completion" {
+		if err := printCompletion(flag.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// fmt --check checks the formatting rather than the output
+	check := *checkFlag || mode == "check"
+
+	filename := "./README.md"
+	if flag.NArg() == 1 {
+		filename = flag.Arg(0)
+	}
+
+	if mode == "init" {
+		if err := initProject(filename, firstString(*configFlag, defaultConfigFile)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	tempDir = *tmpdirFlag
+	replaceSymlinks = *replaceSymlinkFlag
+
+	if mode == "apply" {
+		if flag.NArg() != 1 {
+			fmt.Fprintf(os.Stderr, "Error: apply takes the patch to apply\n")
+			os.Exit(1)
+		}
+		applied, err := applyPatch(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			exit(1)
+		}
+		fmt.Printf("Applied %s to %s\n", flag.Arg(0), applied)
+		exit(0)
+	}
+
+	if !contains(reportFormats, *reportFormatFlag) {
+		fmt.Fprintf(os.Stderr, "Error: unknown report format %q\n", *reportFormatFlag)
+		os.Exit(1)
+	}
+
+	if !contains(logFormats, *logFormatFlag) {
+		fmt.Fprintf(os.Stderr, "Error: unknown log format %q\n", *logFormatFlag)
+		os.Exit(1)
+	}
+	if *logFormatFlag == "json" {
+		eventLog = os.Stderr
+	}
+
+	// with --patch or --output - stdout is only the patch or the updated
+	// file, so everything else readup prints goes to stderr
+	stdout := os.Stdout
+	if *patchFlag || *outputFlag == "-" {
+		os.Stdout = os.Stderr
+	}
+
+	// with --all or a directory readup runs many documents, each with
+	// the config for its own directory
+	info, statErr := os.Stat(filename)
+	multi := *allFlag || (statErr == nil && info.IsDir())
+	configDir := filepath.Dir(filename)
+	if multi {
+		configDir = "."
+	}
+	cfg, err := loadConfigIn(*configFlag, configDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	storeName := *storeFlag
+	if storeName == "" {
+		storeName = cfg.storePath(filename)
+	}
+	writeHooks = cfg.Hooks
+	colors, err = cfg.Colors.palette()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if cfg.OutputIndent != nil {
+		outputIndent, err = parseIndent(*cfg.OutputIndent)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	if *diffContextFlag >= 0 {
+		diffContext = *diffContextFlag
+	} else if cfg.DiffContext != nil {
+		if *cfg.DiffContext < 0 {
+			fmt.Fprintf(os.Stderr, "Error: diff_context can't be negative\n")
+			os.Exit(1)
+		}
+		diffContext = *cfg.DiffContext
+	}
+	diffStyle := firstString(*diffStyleFlag, cfg.DiffStyle, "file")
+	if !contains(diffStyles, diffStyle) {
+		fmt.Fprintf(os.Stderr, "Error: unknown diff style %q, must be one of %s\n", diffStyle, strings.Join(diffStyles, ", "))
+		os.Exit(1)
+	}
+	if err := cfg.Notify.check(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if err := cfg.Deliver.check(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	var pathDirs []string
+	if *pathPrependFlag != "" {
+		pathDirs = filepath.SplitList(*pathPrependFlag)
+	}
+	flagOpts := Options{
+		Config:             *configFlag,
+		Normalize:          splitList(*normalizeFlag),
+		Deterministic:      *deterministicFlag,
+		CleanEnv:           *cleanEnvFlag,
+		PathPrepend:        pathDirs,
+		Stamp:              *stampFlag,
+		StrictToolVersions: *strictToolVersionsFlag,
+		MaxOutput:          *maxOutputFlag,
+		Shell:              *shellFlag,
+		Wrapper:            *wrapperFlag,
+		Term:               *termFlag,
+		Columns:            *columnsFlag,
+		Lines:              *linesFlag,
+		Capture:            *captureFlag,
+		Wrap:               *wrapFlag,
+		ExpandTabs:         *expandTabsFlag,
+		TrimTrailingSpace:  *trimTrailingSpaceFlag,
+		TrimBlankLines:     *trimBlankLinesFlag,
+		IgnoreWhitespace:   *ignoreWhitespaceFlag,
+		IgnoreANSI:         *ignoreANSIFlag,
+		Only:               splitList(*onlyFlag),
+		Match:              *matchFlag,
+		ApplySuccessful:    *applySuccessfulFlag,
+		Highlight:          *highlightFlag,
+	}
+	opts, err := newOptions(cfg, flagOpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	var files []string
+	if multi {
+		if err := checkMultiFile(mode); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		dir := filename
+		if *allFlag {
+			dir = ""
+		}
+		files, err = workspaceFiles(cfg, dir, splitList(*includeFlag), splitList(*excludeFlag), !*noGitignoreFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: no documents found to run\n")
+			os.Exit(1)
+		}
+	} else if *includeFlag != "" || *excludeFlag != "" {
+		fmt.Fprintf(os.Stderr, "Error: --include and --exclude only work with --all or a directory\n")
+		os.Exit(1)
+	}
+
+	if *offlineFlag && mode != "replay" {
+		switch cfg.OfflineScope {
+		case "", "network":
+		case "all":
+			opts.offlineAll = true
+		default:
+			fmt.Fprintf(os.Stderr, "Error: invalid offline_scope %q, must be \"network\" or \"all\"\n", cfg.OfflineScope)
+			os.Exit(1)
+		}
+
+		opts.offline, err = loadOutputStoreIfExists(storeName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if *sinceFlag != "" {
+		opts.changed, err = gitChangedLines(*sinceFlag, filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	notify := cfg.Notify
+	notify.Desktop = notify.Desktop || *notifyFlag
+
+	if files != nil {
+		handleInterrupts()
+		exit(runFiles(files, multiRun{
+			mode:         mode,
+			jobs:         jobs(*jobsFlag, cfg),
+			diffStyle:    diffStyle,
+			report:       *reportFlag,
+			reportFormat: *reportFormatFlag,
+			timings:      *timingsFlag,
+			commit:       *commitFlag,
+			notify:       notify,
+			deliver:      cfg.Deliver,
+			flags:        flagOpts,
+			opts:         opts,
+		}))
+	}
+
+	if *pickFlag {
+		opts.picked, err = pickBlocks(filename, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if mode == "lint" {
+		problems, err := lintFile(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if printLintProblems(filename, problems) > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if mode == "lsp" {
+		// stdout is for talking to the editor
+		out := os.Stdout
+		os.Stdout = os.Stderr
+		os.Exit(serveLSP(os.Stdin, out, opts))
+	}
+
+	if mode == "serve" {
+		if err := serve(*listenFlag, *uiFlag, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if mode == "preview" {
+		if err := preview(*listenFlag, filename, *githubMarkdownFlag, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if mode == "list" {
+		if err := listBlocks(filename, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	switch mode {
+	case "record":
+		opts.record = newOutputStore()
+	case "replay":
+		opts.replay, err = loadOutputStore(storeName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	handleInterrupts()
+	// the file is only written once the run's finished, but it's locked
+	// for the whole run so a concurrent one can't overwrite it
+	if !check && !*patchFlag && *outputFlag == "" && mode != "diff" && mode != "test" {
+		// rather than find out after running every block
+		if err := checkWritable(filename); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if err := lockFile(filename); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// the file as it was when the run started, to merge with any edits
+	// made while it ran
+	original, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		exit(1)
+	}
+
+	var content string
+	var results []*blockResult
+	start := time.Now()
+	if mode == "fmt" {
+		content, err = formatFile(filename)
+	} else {
+		content, results, err = readup(filename, opts)
+	}
+	if *metricsFlag != "" {
+		if err := writeMetrics(*metricsFlag, filename, results, err, time.Since(start)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			exit(1)
+		}
+	}
+	if err := notifyRun(notify, filename, results, err, time.Since(start)); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: couldn't send notification: %s\n", err.Error())
+	}
+	// with --apply-successful the blocks that failed are left alone and
+	// the rest are updated, but readup still exits with status 1
+	status := 0
+	var failures blockErrors
+	if errors.As(err, &failures) {
+		for _, failure := range failures {
+			logEvent("error", map[string]interface{}{"file": filename, "error": failure.Error()})
+		}
+		fmt.Fprintf(os.Stderr, "%d blocks failed and were left as they were:\n", len(failures))
+		for _, failure := range failures {
+			fmt.Fprintf(os.Stderr, "  %s\n", failure.Error())
+		}
+		status = 1
+	} else if err != nil {
+		logEvent("error", map[string]interface{}{"file": filename, "error": err.Error()})
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		exit(1)
+	}
+
+	if *timingsFlag {
+		printTimings("Timings:", results)
+	}
+
+	if *reportFlag != "" {
+		if err := writeReport(*reportFlag, *reportFormatFlag, []fileResults{{filename: filename, results: results, failures: failures}}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			exit(1)
+		}
+	}
+
+	if opts.record != nil {
+		if err := opts.record.save(storeName); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			exit(1)
+		}
+		fmt.Printf("Recorded %d blocks to %s\n", len(opts.record.Blocks), storeName)
+	}
+
+	tmpName, err := writeTempFile(filename, content)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		exit(1)
+	}
+	if *keepTempFlag {
+		keepTempFile(tmpName)
+		fmt.Fprintf(os.Stderr, "Kept temp file %s\n", tmpName)
+	}
+
+	diffName := filename
+	if *diffBaseFlag != "" {
+		base, err := gitShow(*diffBaseFlag, filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			exit(1)
+		}
+
+		diffName, err = writeTempFile(filename, base)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			exit(1)
+		}
+	}
+
+	label := diffLabel(filename)
+	diffOut, err := unifiedDiff(label, diffName, tmpName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		exit(1)
+	}
+
+	base, err := os.ReadFile(diffName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		exit(1)
+	}
+	if diffName != filename {
+		removeTempFile(diffName)
+	}
+	patch := readupPatch(label, string(base), diffOut)
+
+	if mode != "fmt" {
+		if err := deliverChanges(cfg.Deliver, filename, results, diffOut); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			exit(1)
+		}
+	}
+
+	if *diffOutputFlag != "" {
+		if err := os.WriteFile(*diffOutputFlag, []byte(patch), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			exit(1)
+		}
+	}
+
+	if *patchFlag {
+		fmt.Fprint(stdout, patch)
+		exit(status)
+	}
+
+	if mode == "test" {
+		removeTempFile(tmpName)
+		failed := printTestResults(filename, results)
+		if failed > 0 {
+			fmt.Printf("%d of %d blocks out of date\n", failed, len(results))
+			exit(1)
+		}
+		fmt.Printf("%d blocks ok\n", len(results))
+		exit(status)
+	}
+
+	if err := printDiff(diffStyle, filename, string(base), diffOut, results); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		exit(1)
+	}
+
+	if mode == "diff" {
+		removeTempFile(tmpName)
+		exit(status)
+	}
+
+	if check {
+		removeTempFile(tmpName)
+
+		upToDate := string(original) == content
+		if *githubPRFlag != 0 {
+			report := checkReport(filename, results, diffOut, upToDate)
+			if err := githubReport(*githubPRFlag, filename, report, upToDate); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+				exit(1)
+			}
+		}
+
+		logEvent("check", map[string]interface{}{"file": filename, "up_to_date": upToDate})
+		if !upToDate {
+			if mode == "fmt" {
+				fmt.Printf("%s isn't formatted, run readup fmt to fix it\n", filename)
+				exit(1)
+			}
+			fmt.Printf("%s %s, run readup to update it\n", filename, paint(colors.failure, "is out of date"))
+			exit(1)
+		}
+		fmt.Printf("%s %s\n", filename, paint(colors.success, "is up to date"))
+		exit(status)
+	}
+
+	if *outputFlag == "-" {
+		fmt.Fprint(stdout, content)
+		exit(status)
+	}
+	if *outputFlag != "" {
+		if err := writeFile(*outputFlag, content); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			exit(1)
+		}
+		logEvent("write", map[string]interface{}{"file": *outputFlag})
+		fmt.Printf("Wrote %s\n", *outputFlag)
+		exit(status)
+	}
+
+	// with nothing to write there's nothing to ask about, or commit
+	if string(original) == content {
+		removeTempFile(tmpName)
+		fmt.Printf("%s %s\n", filename, paint(colors.success, "is up to date"))
+		exit(status)
+	}
+
+	// Ask the user to confirm whether they want to update the file
+	if !confirm("Update file?") {
+		exit(status)
+	}
+
+	// replace the original file with the updated content
+	content, err = mergeChanges(filename, string(original), content)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		exit(1)
+	}
+	err = writeFile(filename, content)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		exit(1)
+	}
+
+	// remove the temp file
+	err = removeTempFile(tmpName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		exit(1)
+	}
+	logEvent("write", map[string]interface{}{"file": filename})
+
+	if *commitFlag != "" {
+		if err := gitCommit([]string{filename}, *commitFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			exit(1)
+		}
+		fmt.Printf("Committed %s\n", filename)
+	}
+
+	exit(status)
+}
@@ -0,0 +1,70 @@
+package readup
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// `readup man` prints a man page generated from the subcommands and
+// flags, for packagers. It's left out of the usage since few people
+// will want it.
+
+const manCommand = "man"
+
+// manPage() returns readup's man page in roff.
+func manPage() string {
+	v, _, d := buildInfo()
+	date := time.Now().UTC().Format("2006-01-02")
+	if t, err := time.Parse(time.RFC3339, d); err == nil {
+		date = t.Format("2006-01-02")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, ".TH READUP 1 %q \"readup %s\" \"User Commands\"\n", date, roffEscape(v))
+	b.WriteString(".SH NAME\nreadup \\- keep a README up to date with command output\n")
+	b.WriteString(".SH SYNOPSIS\n.B readup\n[\\fIcommand\\fR] [\\fIflags\\fR] [\\fIfile\\fR]\n")
+	b.WriteString(".SH DESCRIPTION\n" +
+		"readup looks for code blocks in a Markdown file whose first line is\n" +
+		".BR \"> \" command ,\n" +
+		"runs each command and replaces the rest of the block with its output.\n" +
+		"The file defaults to ./README.md.\n")
+
+	b.WriteString(".SH COMMANDS\n")
+	for _, sc := range subcommands {
+		fmt.Fprintf(&b, ".TP\n.B %s\n%s\n", sc.name, roffEscape(sc.usage))
+	}
+
+	b.WriteString(".SH OPTIONS\n")
+	flag.VisitAll(func(f *flag.Flag) {
+		name, usage := flag.UnquoteUsage(f)
+		fmt.Fprintf(&b, ".TP\n.B \\-\\-%s", roffEscape(f.Name))
+		if name != "" {
+			fmt.Fprintf(&b, " \\fI%s\\fR", roffEscape(name))
+		}
+		b.WriteString("\n" + roffEscape(usage))
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" {
+			fmt.Fprintf(&b, " (default %s)", roffEscape(f.DefValue))
+		}
+		b.WriteString("\n")
+	})
+
+	fmt.Fprintf(&b, ".SH FILES\n.TP\n.I %s\nthe config file read from the current directory if \\-\\-config isn't given\n", defaultConfigFile)
+	b.WriteString(".SH SEE ALSO\nhttps://github.com/" + releaseRepo + "\n")
+	return b.String()
+}
+
+// roffEscape() escapes s for use as text in a man page.
+func roffEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		// a leading dot or quote would start a request
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
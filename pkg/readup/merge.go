@@ -0,0 +1,51 @@
+package readup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// mergeChanges() returns what to write to filename, whose content was
+// original when readup read it and which readup changed to content. If
+// the file has been edited since, those edits are merged with readup's
+// changes with git merge-file, which fails if they conflict.
+func mergeChanges(filename, original, content string) (string, error) {
+	current, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	if string(current) == original {
+		return content, nil
+	}
+	fmt.Printf("%s changed while readup was running, merging the changes\n", filename)
+
+	var names []string
+	defer func() {
+		for _, name := range names {
+			removeTempFile(name)
+		}
+	}()
+	for _, version := range []string{string(current), original, content} {
+		name, err := writeTempFile(filename, version)
+		if err != nil {
+			return "", err
+		}
+		names = append(names, name)
+	}
+
+	command := exec.Command("git", "merge-file", "-p",
+		"-L", filename, "-L", "original", "-L", "readup", names[0], names[1], names[2])
+	merged, err := command.Output()
+	var exitErr *exec.ExitError
+	// git merge-file exits with the number of conflicts, or 255 if it
+	// fails
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 && exitErr.ExitCode() < 128 {
+		return "", fmt.Errorf("%s changed while readup was running, and %d of the changes conflict with readup's, run readup again", filename, exitErr.ExitCode())
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s changed while readup was running, and merging failed: %w", filename, err)
+	}
+	return string(merged), nil
+}
@@ -0,0 +1,44 @@
+package readup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeChanges(t *testing.T) {
+	const original = "# T\n\nintro\n\n```\n> echo hi\nold\n```\n\noutro\n"
+	const content = "# T\n\nintro\n\n```\n> echo hi\nhi\n```\n\noutro\n"
+	tests := []struct {
+		name    string
+		current string
+		want    string
+		err     string
+	}{
+		{"unchanged", original, content, ""},
+		{"edited elsewhere", strings.Replace(original, "outro", "the end", 1), strings.Replace(content, "outro", "the end", 1), ""},
+		{"conflict", strings.Replace(original, "old", "edited", 1), "", "1 of the changes conflict"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "README.md")
+			if err := os.WriteFile(filename, []byte(tt.current), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := mergeChanges(filename, original, content)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("mergeChanges() = %v, want an error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("mergeChanges() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package readup
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Normalizers rewrite volatile substrings of command output (timestamps,
+// temp paths, etc.) to stable placeholders so that the README doesn't
+// change every time readup is run.
+
+// normalizeRule is a single regular expression rewrite. If valid is set,
+// a match is only replaced when valid() returns true for it.
+type normalizeRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+	valid       func(string) bool
+}
+
+// normalizer is a named set of rules that are applied in order.
+type normalizer struct {
+	name  string
+	rules []normalizeRule
+}
+
+// builtinNormalizers are applied in this order when several are selected,
+// which matters because e.g. a UUID can look like part of an IPv6 address.
+var builtinNormalizers = []*normalizer{
+	{
+		name: "uuid",
+		rules: []normalizeRule{
+			{pattern: regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), replacement: "<UUID>"},
+		},
+	},
+	{
+		name: "timestamp",
+		rules: []normalizeRule{
+			// ISO 8601 dates with an optional time and zone
+			{pattern: regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}(?:[T ]\d{2}:\d{2}(?::\d{2}(?:\.\d+)?)?(?:Z|[+-]\d{2}:?\d{2})?)?\b`), replacement: "<TIMESTAMP>"},
+			// date(1) style, e.g. "Mon Jan  2 15:04:05 MST 2006"
+			{pattern: regexp.MustCompile(`\b(?:Mon|Tue|Wed|Thu|Fri|Sat|Sun),? +(?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) +\d{1,2},? +\d{2}:\d{2}:\d{2}(?: +[A-Z]{3,5})? +\d{4}\b`), replacement: "<TIMESTAMP>"},
+			// bare wall clock times
+			{pattern: regexp.MustCompile(`\b\d{2}:\d{2}:\d{2}(?:\.\d+)?\b`), replacement: "<TIMESTAMP>"},
+		},
+	},
+	{
+		name: "duration",
+		rules: []normalizeRule{
+			{pattern: regexp.MustCompile(`\b\d+(?:\.\d+)? ?(?:seconds|secs|sec|milliseconds|msecs)\b`), replacement: "<DURATION>"},
+			// Go style durations, e.g. "1m2.5s" or "350ms"
+			{pattern: regexp.MustCompile(`\b(?:\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h))+\b`), replacement: "<DURATION>"},
+		},
+	},
+	{
+		name: "tmppath",
+		// only paths that start at the root, not e.g. /home/u/proj/tmp/build,
+		// and not the bracket or quote around one
+		rules: []normalizeRule{
+			{pattern: regexp.MustCompile(`(^|[\s'"=:(\[])(?:/private)?/var/folders/[^\s'")\]]+`), replacement: "${1}<TMPPATH>"},
+			{pattern: regexp.MustCompile(`(^|[\s'"=:(\[])(?:/private)?(?:/var)?/tmp/[^\s'")\]]+`), replacement: "${1}<TMPPATH>"},
+		},
+	},
+	{
+		name: "pid",
+		rules: []normalizeRule{
+			{pattern: regexp.MustCompile(`(?i)\b(pid[:=]? *)\d+\b`), replacement: "${1}<PID>"},
+			// shell job notifications, e.g. "[1] 12345"
+			{pattern: regexp.MustCompile(`(?m)^(\[\d+\] )\d+$`), replacement: "${1}<PID>"},
+		},
+	},
+	{
+		name: "ip",
+		rules: []normalizeRule{
+			{pattern: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), replacement: "<IP>", valid: isIP},
+			{pattern: regexp.MustCompile(`(?i)(?:[0-9a-f]{1,4})?(?::[0-9a-f]{0,4}){2,7}`), replacement: "<IP>", valid: isIP},
+		},
+	},
+}
+
+// isIP() reports whether s is a valid IPv4 or IPv6 address, used to weed
+// out false positives like version numbers and clock times.
+func isIP(s string) bool {
+	return len(s) > 2 && net.ParseIP(s) != nil
+}
+
+// apply() runs each of the normalizer's rules over s.
+func (n *normalizer) apply(s string) string {
+	for _, rule := range n.rules {
+		if rule.valid == nil {
+			s = rule.pattern.ReplaceAllString(s, rule.replacement)
+			continue
+		}
+
+		rule := rule
+		s = rule.pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if !rule.valid(match) {
+				return match
+			}
+			return rule.replacement
+		})
+	}
+	return s
+}
+
+// availableNormalizers() returns the builtin normalizers followed by any
+// defined by the config. A config normalizer with the same name as a
+// builtin one replaces it.
+func availableNormalizers(cfg *config) ([]*normalizer, error) {
+	custom, err := cfg.normalizers()
+	if err != nil {
+		return nil, err
+	}
+
+	var normalizers []*normalizer
+	for _, n := range builtinNormalizers {
+		if findNormalizer(n.name, custom) == nil {
+			normalizers = append(normalizers, n)
+		}
+	}
+	return append(normalizers, custom...), nil
+}
+
+// splitList() splits a comma-separated list, dropping empty entries.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// selectNormalizers() returns the normalizers named in names (or all of
+// them if names contains "all"), in the order they appear in available.
+func selectNormalizers(names []string, available []*normalizer) ([]*normalizer, error) {
+	wanted := map[string]bool{}
+	for _, name := range names {
+		if name == "all" {
+			return available, nil
+		}
+		if findNormalizer(name, available) == nil {
+			return nil, fmt.Errorf("unknown normalizer %q (available: %s)",
+				name, strings.Join(normalizerNames(available), ", "))
+		}
+		wanted[name] = true
+	}
+
+	var normalizers []*normalizer
+	for _, n := range available {
+		if wanted[n.name] {
+			normalizers = append(normalizers, n)
+		}
+	}
+	return normalizers, nil
+}
+
+func findNormalizer(name string, normalizers []*normalizer) *normalizer {
+	for _, n := range normalizers {
+		if n.name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+func normalizerNames(normalizers []*normalizer) []string {
+	var names []string
+	for _, n := range normalizers {
+		names = append(names, n.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// normalize() applies each normalizer to s in order.
+func normalize(s string, normalizers []*normalizer) string {
+	for _, n := range normalizers {
+		s = n.apply(s)
+	}
+	return s
+}
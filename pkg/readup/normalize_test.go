@@ -0,0 +1,70 @@
+package readup
+
+import "testing"
+
+func TestNormalizeTmpPath(t *testing.T) {
+	tmppath := []*normalizer{findNormalizer("tmppath", builtinNormalizers)}
+	tests := []struct {
+		in, want string
+	}{
+		{"/tmp/build-123/out.txt", "<TMPPATH>"},
+		{"wrote /tmp/x.log", "wrote <TMPPATH>"},
+		{"/var/tmp/x and /private/var/tmp/y", "<TMPPATH> and <TMPPATH>"},
+		{"/var/folders/ab/cd/T/tmp.1", "<TMPPATH>"},
+		{"/private/var/folders/ab/cd/T/tmp.1", "<TMPPATH>"},
+		{"TMPDIR=/tmp/abc", "TMPDIR=<TMPPATH>"},
+		{"open '/tmp/abc': no such file", "open '<TMPPATH>': no such file"},
+		{"(/tmp/abc)", "(<TMPPATH>)"},
+		{"one\n/tmp/abc", "one\n<TMPPATH>"},
+		{"/home/u/proj/tmp/build", "/home/u/proj/tmp/build"},
+		{"./tmp/build", "./tmp/build"},
+		{"/srv/var/folders/x", "/srv/var/folders/x"},
+	}
+	for _, tt := range tests {
+		if got := normalize(tt.in, tmppath); got != tt.want {
+			t.Errorf("normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"uuid", "id 123e4567-E89B-12d3-a456-426614174000 ok", "id <UUID> ok"},
+		{"iso date", "on 2024-01-02", "on <TIMESTAMP>"},
+		{"iso time", "at 2024-01-02T03:04:05.123+01:00.", "at <TIMESTAMP>."},
+		{"date(1)", "Mon Jan  2 15:04:05 MST 2006", "<TIMESTAMP>"},
+		{"clock", "started 09:30:00", "started <TIMESTAMP>"},
+		{"duration", "took 1.5 seconds, then 1m2.5s and 350ms", "took <DURATION>, then <DURATION> and <DURATION>"},
+		{"pid", "pid=4242 and [1] 999", "pid=<PID> and [1] 999"},
+		{"job", "[1] 999", "[1] <PID>"},
+		{"ipv4", "from 192.168.0.1", "from <IP>"},
+		{"ipv6", "on ::1 and fe80::1", "on <IP> and <IP>"},
+		{"version isn't an ip", "v1.2.3.4 or 1.2.3", "v1.2.3.4 or 1.2.3"},
+		{"uuid isn't an ip", "123e4567-e89b-12d3-a456-426614174000", "<UUID>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalize(tt.in, builtinNormalizers); got != tt.want {
+				t.Errorf("normalize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectNormalizers(t *testing.T) {
+	selected, err := selectNormalizers([]string{"pid", "uuid"}, builtinNormalizers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := []string{selected[0].name, selected[1].name}; names[0] != "uuid" || names[1] != "pid" {
+		t.Errorf("selectNormalizers() = %v, want them in the builtin order", names)
+	}
+	if all, err := selectNormalizers([]string{"all"}, builtinNormalizers); err != nil || len(all) != len(builtinNormalizers) {
+		t.Errorf("selectNormalizers(all) = %d normalizers, %v", len(all), err)
+	}
+	if _, err := selectNormalizers([]string{"nope"}, builtinNormalizers); err == nil {
+		t.Error("selectNormalizers() of an unknown normalizer succeeded")
+	}
+}
@@ -0,0 +1,179 @@
+package readup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Notifications say a run has finished, or failed, for long runs that
+// nobody sits and watches. The config chooses a desktop notification, a
+// webhook, or both, and how long a run has to take before either is
+// sent:
+//
+//	notify:
+//	  desktop: true
+//	  webhook: https://hooks.slack.com/services/...
+//	  format: slack
+//	  min_duration: 5m
+//
+// A webhook gets a JSON summary of the run, or with format: slack a
+// message in the form Slack's incoming webhooks take.
+
+var notifyFormats = []string{"json", "slack"}
+
+// notifyConfig is the notify section of the config.
+type notifyConfig struct {
+	Desktop     bool   `yaml:"desktop"`
+	Webhook     string `yaml:"webhook"`
+	Format      string `yaml:"format"`
+	MinDuration string `yaml:"min_duration"`
+}
+
+// runSummary is the JSON a webhook is sent.
+type runSummary struct {
+	File       string       `json:"file"`
+	OK         bool         `json:"ok"`
+	Error      string       `json:"error,omitempty"`
+	Blocks     int          `json:"blocks"`
+	DurationMS float64      `json:"duration_ms"`
+	Changed    []jsonChange `json:"changed"`
+}
+
+type jsonChange struct {
+	Line    int    `json:"line"`
+	Command string `json:"command"`
+}
+
+// maxNotifyBlocks is how many changed blocks a notification lists.
+const maxNotifyBlocks = 10
+
+// check() returns an error if the config's notify section is invalid,
+// which is better found out before a long run than after it.
+func (c notifyConfig) check() error {
+	if c.Format != "" && !contains(notifyFormats, c.Format) {
+		return fmt.Errorf("unknown notify format %q, must be one of %s", c.Format, strings.Join(notifyFormats, ", "))
+	}
+	if c.MinDuration != "" {
+		if _, err := time.ParseDuration(c.MinDuration); err != nil {
+			return fmt.Errorf("invalid notify min_duration: %w", err)
+		}
+	}
+	return nil
+}
+
+// notifyRun() sends the notifications cfg asks for about a run of
+// filename which took elapsed, and ended with runErr.
+func notifyRun(cfg notifyConfig, filename string, results []*blockResult, runErr error, elapsed time.Duration) error {
+	if !cfg.Desktop && cfg.Webhook == "" {
+		return nil
+	}
+	if threshold, err := time.ParseDuration(cfg.MinDuration); err == nil && elapsed < threshold {
+		return nil
+	}
+
+	summary := runSummary{
+		File:       filename,
+		OK:         runErr == nil,
+		Blocks:     len(results),
+		DurationMS: float64(elapsed.Microseconds()) / 1000,
+		Changed:    []jsonChange{},
+	}
+	if runErr != nil {
+		summary.Error = runErr.Error()
+	}
+	for _, result := range results {
+		if result.stale() {
+			summary.Changed = append(summary.Changed, jsonChange{Line: result.line, Command: result.command})
+		}
+	}
+	title, body := notifyMessage(summary, elapsed)
+
+	if cfg.Desktop {
+		if err := desktopNotify(title, body); err != nil {
+			return err
+		}
+	}
+	if cfg.Webhook != "" {
+		var payload interface{} = summary
+		if cfg.Format == "slack" {
+			payload = map[string]string{"text": "*" + title + "*\n" + body}
+		}
+		if err := postWebhook(cfg.Webhook, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyMessage() returns the title and text of a notification about
+// the run summary describes.
+func notifyMessage(summary runSummary, elapsed time.Duration) (string, string) {
+	took := elapsed.Round(time.Second)
+	if !summary.OK {
+		return fmt.Sprintf("readup failed on %s after %s", summary.File, took), summary.Error
+	}
+
+	title := fmt.Sprintf("readup finished %s in %s", summary.File, took)
+	if len(summary.Changed) == 0 {
+		return title, fmt.Sprintf("All %d blocks are up to date", summary.Blocks)
+	}
+	lines := []string{fmt.Sprintf("%d of %d blocks changed:", len(summary.Changed), summary.Blocks)}
+	for i, change := range summary.Changed {
+		if i == maxNotifyBlocks {
+			lines = append(lines, fmt.Sprintf("and %d more", len(summary.Changed)-i))
+			break
+		}
+		command, _, _ := strings.Cut(change.Command, "\n")
+		lines = append(lines, fmt.Sprintf("line %d: %s", change.Line, command))
+	}
+	return title, strings.Join(lines, "\n")
+}
+
+// desktopNotify() shows a desktop notification with notify-send, or on
+// macOS with osascript.
+func desktopNotify(title, body string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptQuote(body), appleScriptQuote(title))
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		return fmt.Errorf("desktop notifications aren't supported on Windows")
+	default:
+		cmd = exec.Command("notify-send", title, body)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", cmd.Args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func appleScriptQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// postWebhook() posts payload to url as JSON.
+func postWebhook(url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("webhook %s: %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package readup
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Post-processing of captured command output, applied after
+// normalization so that placeholders are what gets measured.
+
+// appendLine() adds line to the end of s, keeping s's trailing newline if
+// it has one.
+func appendLine(s, line string) string {
+	if s == "" {
+		return line
+	}
+	if strings.HasSuffix(s, "\n") {
+		return s + line + "\n"
+	}
+	return s + "\n" + line
+}
+
+// trimTrailingSpace() removes whitespace from the end of each line of s.
+func trimTrailingSpace(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// trimBlankLines() removes blank lines, including the final newline,
+// from the end of s.
+func trimBlankLines(s string) string {
+	lines := strings.Split(s, "\n")
+	end := len(lines)
+	for end > 0 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return strings.Join(lines[:end], "\n")
+}
+
+// expandTabs() replaces tabs in s with spaces up to the next tab stop,
+// with stops every width columns, like expand(1). Columns are counted by
+// display width, so wide characters before a tab don't misalign it.
+func expandTabs(s string, width int) string {
+	if width <= 0 || !strings.Contains(s, "\t") {
+		return s
+	}
+
+	var b strings.Builder
+	col := 0
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '\x1b':
+			n := escapeLength(s[i:])
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+		case '\t':
+			spaces := width - col%width
+			b.WriteString(strings.Repeat(" ", spaces))
+			col += spaces
+			i++
+			continue
+		case '\n':
+			col = -1
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteRune(r)
+		col += runeWidth(r)
+		i += size
+	}
+	return b.String()
+}
+
+// softWrap() wraps lines of s longer than width columns, breaking at the
+// last space before the limit where possible. Lines are measured by
+// display width, and escape sequences don't count towards it.
+func softWrap(s string, width int) string {
+	if width <= 0 {
+		return s
+	}
+
+	var wrapped []string
+	for _, line := range strings.Split(s, "\n") {
+		for {
+			cut := wrapPoint(line, width)
+			if cut == 0 {
+				break
+			}
+			wrapped = append(wrapped, strings.TrimRight(line[:cut], " "))
+			line = strings.TrimLeft(line[cut:], " ")
+		}
+		wrapped = append(wrapped, line)
+	}
+	return strings.Join(wrapped, "\n")
+}
+
+// wrapPoint() returns the byte offset to break line at so that the first
+// part fits in width columns, or 0 if it already fits.
+func wrapPoint(line string, width int) int {
+	col := 0
+	lastSpace := -1
+	for i := 0; i < len(line); {
+		if line[i] == '\x1b' {
+			i += escapeLength(line[i:])
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(line[i:])
+		w := runeWidth(r)
+		// a wide character that would straddle the limit goes on the
+		// next line, as it would on a terminal
+		if col+w > width && col > 0 {
+			if r == ' ' {
+				return i
+			}
+			if lastSpace > 0 {
+				return lastSpace + 1
+			}
+			return i
+		}
+		if r == ' ' {
+			lastSpace = i
+		}
+		col += w
+		i += size
+	}
+	return 0
+}
+
+// stripEscapes() returns s without its escape sequences.
+func stripEscapes(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			i += escapeLength(s[i:])
+			continue
+		}
+		b.WriteByte(s[i])
+		i++
+	}
+	return b.String()
+}
+
+// escapeLength() returns the length of the escape sequence at the start
+// of s, which must start with ESC.
+func escapeLength(s string) int {
+	if len(s) < 2 || s[1] != '[' {
+		return 1
+	}
+	for i := 2; i < len(s); i++ {
+		if s[i] >= 0x40 && s[i] <= 0x7e {
+			return i + 1
+		}
+	}
+	return len(s)
+}
@@ -0,0 +1,253 @@
+package readup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Patches written by --patch and --diff-output start with a header
+// naming the file and the checksum of the version they were made
+// against, which git apply and patch skip over. `readup apply` uses it
+// to refuse a patch for a different version of the file, so output
+// recorded on CI can be applied locally.
+
+const (
+	patchFileHeader = "# readup-patch: "
+	patchBaseHeader = "# readup-base: sha256:"
+)
+
+// diffLabel() returns the name of filename in a patch.
+func diffLabel(filename string) string {
+	return filepath.ToSlash(filepath.Clean(filename))
+}
+
+// diffContext is how many lines of context diffs have around each
+// change.
+var diffContext = 3
+
+// unifiedDiff() returns a unified diff from the file oldName to newName
+// as a patch for the file label.
+func unifiedDiff(label, oldName, newName string) (string, error) {
+	// the labels make the diff a patch for the file rather than the
+	// temp files, and diff runs directly rather than in a terminal so
+	// its output, carriage returns and all, is exactly the patch
+	path := patchPath(label)
+	cmd := exec.Command("diff", "-U", strconv.Itoa(diffContext), "-L", "a/"+path, "-L", "b/"+path, oldName, newName)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	// diff exits with status 1 when the files differ, and 2 if it
+	// couldn't compare them
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("diff failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// patchPath() returns label as a path in a patch's --- and +++ lines,
+// which are relative, so an absolute path loses its leading slash and
+// any drive letter.
+func patchPath(label string) string {
+	label = strings.TrimPrefix(label, filepath.VolumeName(label))
+	return strings.TrimLeft(label, "/")
+}
+
+// contentDiff() returns a unified diff of filename from original to
+// content.
+func contentDiff(filename, original, content string) (string, error) {
+	oldName, err := writeTempFile(filename, original)
+	if err != nil {
+		return "", err
+	}
+	defer removeTempFile(oldName)
+	newName, err := writeTempFile(filename, content)
+	if err != nil {
+		return "", err
+	}
+	defer removeTempFile(newName)
+	return unifiedDiff(diffLabel(filename), oldName, newName)
+}
+
+// blockDiff() returns the hunks of a unified diff from a block's
+// previous output to its new output, without the file header lines.
+func blockDiff(result *blockResult) (string, error) {
+	label := fmt.Sprintf("line-%d", result.line)
+	diff, err := contentDiff(label, result.previous+"\n", result.output+"\n")
+	if err != nil {
+		return "", err
+	}
+	lines := strings.SplitN(diff, "\n", 3)
+	if len(lines) < 3 {
+		return "", nil
+	}
+	return lines[2], nil
+}
+
+// readupPatch() returns diff, of filename's base content, with the
+// header `readup apply` checks, or "" if there are no changes.
+func readupPatch(filename, base, diff string) string {
+	if diff == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(base))
+	return fmt.Sprintf("%s%s\n%s%s\n%s", patchFileHeader, filename, patchBaseHeader, hex.EncodeToString(sum[:]), diff)
+}
+
+// hunkPattern matches the header of a hunk in a unified diff.
+var hunkPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+\d+(?:,\d+)? @@`)
+
+// patchLine is a line of a hunk: kind is ' ' for context, '-' for a
+// removed line or '+' for an added one, and text includes its newline
+// unless it's the last line of a file without one.
+type patchLine struct {
+	kind byte
+	text string
+}
+
+type hunk struct {
+	// start is the index of the first original line the hunk covers
+	start int
+	lines []patchLine
+}
+
+// applyPatch() applies a patch made by readup to the file it names,
+// after checking the file is the version the patch was made against.
+// It returns the name of the file.
+func applyPatch(patchName string) (string, error) {
+	data, err := os.ReadFile(patchName)
+	if err != nil {
+		return "", err
+	}
+
+	var filename, base string
+	var hunks []*hunk
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	scanner.Buffer(nil, 1<<30)
+	// a carriage return at the end of a line is part of the document's
+	// line, not the patch's line ending
+	scanner.Split(scanRawLines)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, patchFileHeader):
+			filename = strings.TrimPrefix(line, patchFileHeader)
+		case strings.HasPrefix(line, patchBaseHeader):
+			base = strings.TrimPrefix(line, patchBaseHeader)
+		case strings.HasPrefix(line, "@@"):
+			match := hunkPattern.FindStringSubmatch(line)
+			if match == nil {
+				return "", fmt.Errorf("%s: bad hunk header %q", patchName, line)
+			}
+			start, _ := strconv.Atoi(match[1])
+			// a hunk that removes nothing starts after line start
+			if match[2] != "0" {
+				start--
+			}
+			hunks = append(hunks, &hunk{start: start})
+		case len(hunks) == 0:
+			// the --- and +++ lines, or anything else before the changes
+		case strings.HasPrefix(line, `\`):
+			// "\ No newline at end of file" applies to the line before
+			current := hunks[len(hunks)-1]
+			if n := len(current.lines); n > 0 {
+				current.lines[n-1].text = strings.TrimSuffix(current.lines[n-1].text, "\n")
+			}
+		case line == "" || strings.ContainsRune(" -+", rune(line[0])):
+			kind := byte(' ')
+			if line != "" {
+				kind, line = line[0], line[1:]
+			}
+			current := hunks[len(hunks)-1]
+			current.lines = append(current.lines, patchLine{kind: kind, text: line + "\n"})
+		default:
+			return "", fmt.Errorf("%s: unexpected line %q", patchName, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if filename == "" || base == "" {
+		return "", fmt.Errorf("%s wasn't made by readup --patch or --diff-output", patchName)
+	}
+
+	if err := lockFile(filename); err != nil {
+		return "", err
+	}
+	original, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(original)
+	if hex.EncodeToString(sum[:]) != base {
+		return "", fmt.Errorf("%s has changed since %s was made, run readup again instead", filename, patchName)
+	}
+
+	patched, err := applyHunks(string(original), hunks)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", patchName, err)
+	}
+	return filename, writeFile(filename, patched)
+}
+
+// scanRawLines() is bufio.ScanLines, but keeps a line's carriage return.
+func scanRawLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// applyHunks() returns original with hunks applied, in order.
+func applyHunks(original string, hunks []*hunk) (string, error) {
+	lines := strings.SplitAfter(original, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var out strings.Builder
+	next := 0
+	for _, h := range hunks {
+		if h.start < next || h.start > len(lines) {
+			return "", fmt.Errorf("hunk at line %d is out of order or past the end of the file", h.start+1)
+		}
+		for _, line := range lines[next:h.start] {
+			out.WriteString(line)
+		}
+		next = h.start
+
+		for _, line := range h.lines {
+			if line.kind == '+' {
+				out.WriteString(line.text)
+				continue
+			}
+			if next >= len(lines) || lines[next] != line.text {
+				return "", fmt.Errorf("line %d doesn't match the patch", next+1)
+			}
+			if line.kind == ' ' {
+				out.WriteString(line.text)
+			}
+			next++
+		}
+	}
+	for _, line := range lines[next:] {
+		out.WriteString(line)
+	}
+	return out.String(), nil
+}
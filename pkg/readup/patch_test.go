@@ -0,0 +1,109 @@
+package readup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPatchPath(t *testing.T) {
+	tests := []struct {
+		label string
+		want  string
+	}{
+		{"README.md", "README.md"},
+		{"docs/guide.md", "docs/guide.md"},
+		{"/tmp/x/README.md", "tmp/x/README.md"},
+	}
+	for _, tt := range tests {
+		if got := patchPath(tt.label); got != tt.want {
+			t.Errorf("patchPath(%q) = %q, want %q", tt.label, got, tt.want)
+		}
+	}
+}
+
+func TestContentDiff(t *testing.T) {
+	diff, err := contentDiff("/tmp/README.md", "a\nb\nc\n", "a\nB\nc\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--- a/tmp/README.md\n+++ b/tmp/README.md\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"
+	if diff != want {
+		t.Errorf("contentDiff() = %q, want %q", diff, want)
+	}
+
+	diff, err = contentDiff("README.md", "same\n", "same\n")
+	if err != nil || diff != "" {
+		t.Errorf("contentDiff() of unchanged content = %q, %v", diff, err)
+	}
+}
+
+func TestUnifiedDiffFails(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := unifiedDiff("x", filepath.Join(dir, "missing"), filepath.Join(dir, "also-missing")); err == nil {
+		t.Error("unifiedDiff() of missing files succeeded")
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		content  string
+	}{
+		{"change", "# T\n\n```\n> echo hi\nold\n```\n", "# T\n\n```\n> echo hi\nhi\n```\n"},
+		{"crlf", "# T\r\n\r\n```\r\n> echo hi\r\nold\r\n```\r\n", "# T\r\n\r\n```\r\n> echo hi\r\nhi\r\n```\r\n"},
+		{"bare carriage return", "a\nb\rc\nd\n", "a\nb\rC\nd\n"},
+		{"no newline at end", "a\nb", "a\nc"},
+		{"add to empty", "", "a\n"},
+		{"several hunks", strings.Repeat("x\n", 20) + "a\n", "b\n" + strings.Repeat("x\n", 20) + "c\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "README.md")
+			if err := os.WriteFile(filename, []byte(tt.original), 0644); err != nil {
+				t.Fatal(err)
+			}
+			diff, err := contentDiff(filename, tt.original, tt.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			patchName := filepath.Join(dir, "readup.patch")
+			if err := os.WriteFile(patchName, []byte(readupPatch(filename, tt.original, diff)), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := applyPatch(patchName); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.content {
+				t.Errorf("patched file is %q, want %q", got, tt.content)
+			}
+		})
+	}
+}
+
+func TestApplyPatchChangedFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "README.md")
+	diff, err := contentDiff(filename, "a\n", "b\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchName := filepath.Join(dir, "readup.patch")
+	if err := os.WriteFile(patchName, []byte(readupPatch(filename, "a\n", diff)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, []byte("edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyPatch(patchName); err == nil || !strings.Contains(err.Error(), "has changed") {
+		t.Errorf("applyPatch() to a changed file = %v, want an error", err)
+	}
+}
@@ -0,0 +1,243 @@
+package readup
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+)
+
+// `readup preview` serves the file rendered as GitHub Flavored Markdown,
+// with a button to refresh its blocks. The refreshed output is only
+// shown, not written to the file. The page reloads itself whenever the
+// file, or the refreshed output, changes.
+//
+// The Markdown is rendered locally, and the page loads nothing from
+// elsewhere, so previewing works offline and doesn't send the file
+// anywhere. With --github-markdown it's rendered by GitHub's Markdown
+// API instead, exactly as GitHub would render it, which sends the file
+// to GitHub each time it changes.
+
+// previewServer is the state of a `readup preview` session.
+type previewServer struct {
+	filename string
+	opts     *options
+	// github renders with GitHub's Markdown API rather than locally
+	github bool
+
+	mu sync.Mutex
+	// refreshed is the last refresh of the file's blocks, which is shown
+	// until the file changes
+	refreshed *pendingRun
+	// rendered is the HTML of the Markdown rendered so far, by its
+	// sourceVersion()
+	rendered map[string]string
+}
+
+// maxRendered is how many renderings previewServer keeps.
+const maxRendered = 100
+
+// markdown renders GitHub Flavored Markdown. Raw HTML in the file is
+// left out, as GitHub sanitizes it.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+)
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Filename}} - readup preview</title>
+<style>
+body { max-width: 980px; margin: 0 auto; padding: 16px 45px; }
+.markdown-body { font: 16px/1.5 -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; color: #1f2328; word-wrap: break-word; }
+.markdown-body h1, .markdown-body h2 { padding-bottom: .3em; border-bottom: 1px solid #d0d7de; }
+.markdown-body h1, .markdown-body h2, .markdown-body h3, .markdown-body h4 { margin: 24px 0 16px; font-weight: 600; line-height: 1.25; }
+.markdown-body p, .markdown-body ul, .markdown-body ol, .markdown-body pre, .markdown-body table, .markdown-body blockquote { margin: 0 0 16px; }
+.markdown-body a { color: #0969da; text-decoration: none; }
+.markdown-body code { padding: .2em .4em; font: 85% ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; background: rgba(175, 184, 193, .2); border-radius: 6px; }
+.markdown-body pre { padding: 16px; overflow: auto; line-height: 1.45; background: #f6f8fa; border-radius: 6px; }
+.markdown-body pre code { padding: 0; font-size: 85%; background: none; }
+.markdown-body blockquote { padding: 0 1em; color: #57606a; border-left: .25em solid #d0d7de; }
+.markdown-body table { border-collapse: collapse; }
+.markdown-body th, .markdown-body td { padding: 6px 13px; border: 1px solid #d0d7de; }
+.markdown-body img { max-width: 100%; }
+.readup-bar { display: flex; gap: 12px; align-items: center; padding: 8px 0 16px; font: 14px sans-serif; color: #57606a; }
+.readup-error { color: #cf222e; }
+</style>
+</head>
+<body>
+<div class="readup-bar">
+<strong>{{.Filename}}</strong>
+<span>{{if .Refreshed}}showing refreshed output, which hasn't been written to the file{{else}}showing the file as it is{{end}}</span>
+<form method="post" action="/refresh"><button type="submit">Refresh blocks</button></form>
+</div>
+{{if .Error}}<p class="readup-error">{{.Error}}</p>{{end}}
+<article class="markdown-body">{{.HTML}}</article>
+<script>
+const version = {{.Version}};
+setInterval(async () => {
+  try {
+    const resp = await fetch("/version");
+    if (resp.ok && await resp.text() !== version) location.reload();
+  } catch (e) {}
+}, 1000);
+</script>
+</body>
+</html>
+`))
+
+// preview() serves a preview of filename on addr until it fails,
+// rendered by GitHub if github is set.
+func preview(addr, filename string, github bool, opts *options) error {
+	s := &previewServer{filename: filename, opts: opts, github: github, rendered: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.page)
+	mux.HandleFunc("/version", s.version)
+	mux.HandleFunc("/refresh", s.refresh)
+
+	fmt.Printf("Previewing %s on http://%s\n", filename, addr)
+	return http.ListenAndServe(addr, localOnly(addr, mux))
+}
+
+// current() returns the Markdown to show, and whether it's been
+// refreshed.
+func (s *previewServer) current() (string, bool, error) {
+	data, err := os.ReadFile(s.filename)
+	if err != nil {
+		return "", false, err
+	}
+	if s.refreshed != nil && s.refreshed.original == string(data) {
+		return s.refreshed.content, true, nil
+	}
+	s.refreshed = nil
+	return string(data), false, nil
+}
+
+func sourceVersion(source string) string {
+	sum := sha1.Sum([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *previewServer) page(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	source, refreshed, err := s.current()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var renderErr string
+	rendered, err := s.render(source)
+	if err != nil {
+		// show the Markdown as it is rather than nothing
+		renderErr = err.Error()
+		rendered = "<pre>" + html.EscapeString(source) + "</pre>"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	previewPage.Execute(w, map[string]interface{}{
+		"Filename":  s.filename,
+		"Refreshed": refreshed,
+		"Error":     renderErr,
+		// raw HTML is left out rendering locally, and GitHub's API
+		// sanitizes the HTML it returns
+		"HTML":    template.HTML(rendered),
+		"Version": sourceVersion(source),
+	})
+}
+
+// render() returns source rendered as HTML, rendering it only if it
+// hasn't been already.
+func (s *previewServer) render(source string) (string, error) {
+	version := sourceVersion(source)
+	if rendered, ok := s.rendered[version]; ok {
+		return rendered, nil
+	}
+
+	var rendered string
+	if s.github {
+		var err error
+		rendered, err = githubMarkdown(source)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		var buf bytes.Buffer
+		if err := markdown.Convert([]byte(source), &buf); err != nil {
+			return "", err
+		}
+		rendered = buf.String()
+	}
+
+	if len(s.rendered) >= maxRendered {
+		s.rendered = map[string]string{}
+	}
+	s.rendered[version] = rendered
+	return rendered, nil
+}
+
+func (s *previewServer) version(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	source, _, err := s.current()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, sourceVersion(source))
+}
+
+func (s *previewServer) refresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "refreshing takes a POST from the preview page", http.StatusForbidden)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	content, _, err := runDocument(s.filename, data, s.opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	s.refreshed = &pendingRun{original: string(data), content: content}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// githubMarkdown() renders text the way GitHub renders a README, with
+// links relative to GITHUB_REPOSITORY if it's set.
+func githubMarkdown(text string) (string, error) {
+	payload := map[string]string{"text": text, "mode": "gfm"}
+	if repo := os.Getenv("GITHUB_REPOSITORY"); repo != "" {
+		payload["context"] = repo
+	}
+
+	var rendered string
+	err := githubRequest("POST", githubAPI()+"/markdown", os.Getenv("GITHUB_TOKEN"), payload, &rendered)
+	return rendered, err
+}
@@ -0,0 +1,204 @@
+// Package readup runs the command blocks in a Markdown document and
+// rewrites their output, for programs like static site generators that
+// want to refresh a document as part of their own build:
+//
+//	result, err := readup.Process(file, readup.Options{Filename: "README.md"})
+//
+// Parse() gives tooling the document's blocks themselves, to inspect or
+// rewrite without running anything.
+//
+// The readup command is a thin wrapper around Main().
+package readup
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+// Options are the settings for Process(), matching the readup command's
+// flags of the same names. The zero value reads the nearest .readup.yaml
+// if there is one and otherwise uses readup's defaults.
+type Options struct {
+	// Filename names the document in errors, and defaults to README.md.
+	// Blocks run in the current directory whatever it is.
+	Filename string
+	// Config is the config file to read, by default .readup.yaml in the
+	// current directory or the nearest of its parents
+	Config string
+
+	Normalize          []string
+	Deterministic      bool
+	CleanEnv           bool
+	PathPrepend        []string
+	Stamp              bool
+	StrictToolVersions bool
+	// MaxOutput is a size like 500K or 10M, or 0 for no limit
+	MaxOutput         string
+	Shell             string
+	Wrapper           string
+	Term              string
+	Columns, Lines    int
+	Capture           string
+	Wrap              int
+	ExpandTabs        int
+	TrimTrailingSpace bool
+	TrimBlankLines    bool
+	IgnoreWhitespace  bool
+	IgnoreANSI        bool
+	// Only and Match restrict the run to blocks with these ids, and
+	// blocks whose command matches this regular expression
+	Only  []string
+	Match string
+	// ApplySuccessful keeps going when a block fails, leaving it as it
+	// was. Process() then returns the document with the other blocks
+	// updated, and an error listing the failures.
+	ApplySuccessful bool
+	// Highlight highlights the output printed as blocks run by their
+	// language, see highlight.go
+	Highlight bool
+
+	// BeforeBlock and AfterBlock, if set, are called before and after
+	// each block is run, after the config's hooks. AfterBlock is called
+	// even if the block failed, with whatever results it got. An error
+	// from either stops the run.
+	BeforeBlock func(filename string, block *Block) error
+	AfterBlock  func(filename string, block *Block, results []BlockResult) error
+}
+
+// Result is the document Process() rewrote, and what it found running
+// each block.
+type Result struct {
+	Document string
+	Blocks   []BlockResult
+}
+
+// BlockResult is the result of running one command in a block. A
+// session block has one for each of its commands.
+type BlockResult struct {
+	// Line is the line number of the block's opening fence
+	Line    int
+	Command string
+	// Previous is the output the block held before the run, Output what
+	// it holds after
+	Previous string
+	Output   string
+	ExitCode int
+	Duration time.Duration
+	// Stale is set if the block's output changed
+	Stale bool
+}
+
+// Process() reads a document from r, runs its blocks and returns the
+// document with their output refreshed. Nothing is written to disk, but
+// what it runs is printed to stdout as the readup command does. If a
+// block fails the error says which, and the results so far are returned
+// with it.
+func Process(r io.Reader, o Options) (Result, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Result{}, err
+	}
+	cfg, err := loadConfig(o.Config)
+	if err != nil {
+		return Result{}, err
+	}
+	opts, err := newOptions(cfg, o)
+	if err != nil {
+		return Result{}, err
+	}
+
+	filename := firstString(o.Filename, "README.md")
+	content, results, err := runDocument(filename, data, opts)
+
+	return Result{Document: content, Blocks: exportResults(results)}, err
+}
+
+func exportResults(results []*blockResult) []BlockResult {
+	var exported []BlockResult
+	for _, r := range results {
+		exported = append(exported, BlockResult{
+			Line:     r.line,
+			Command:  r.command,
+			Previous: r.previous,
+			Output:   r.output,
+			ExitCode: r.exitCode,
+			Duration: r.duration,
+			Stale:    r.stale(),
+		})
+	}
+	return exported
+}
+
+// newOptions() returns the options for a run with cfg, overridden by o
+// wherever o is set.
+func newOptions(cfg *config, o Options) (*options, error) {
+	normalizers, err := availableNormalizers(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &options{
+		normalizers: normalizers,
+		normalize:   o.Normalize,
+		baseEnv:     cfg.baseEnv(o.CleanEnv),
+		env:         cfg.env(o.Deterministic),
+		stamp:       o.Stamp || cfg.Stamp,
+
+		strictToolVersions: o.StrictToolVersions || cfg.StrictToolVersions,
+
+		term:    firstString(o.Term, cfg.Term),
+		columns: firstInt(o.Columns, cfg.Columns),
+		lines:   firstInt(o.Lines, cfg.Lines),
+		capture: firstString(o.Capture, cfg.Capture, defaultCapture),
+		wrap:    firstInt(o.Wrap, cfg.Wrap),
+
+		expandTabs:        firstInt(o.ExpandTabs, cfg.ExpandTabs),
+		trimTrailingSpace: o.TrimTrailingSpace || cfg.TrimTrailingSpace,
+		trimBlankLines:    o.TrimBlankLines || cfg.TrimBlankLines,
+		ignoreWhitespace:  o.IgnoreWhitespace || cfg.IgnoreWhitespace,
+		ignoreANSI:        o.IgnoreANSI || cfg.IgnoreANSI,
+		interpreters:      cfg.interpreters(),
+		echo:              firstString(cfg.Echo, "prompt"),
+		compare:           firstString(cfg.Compare, "exact"),
+		shell:             firstString(o.Shell, cfg.Shell),
+		containerEngine:   firstString(cfg.ContainerEngine, "docker"),
+		wrapper:           firstString(o.Wrapper, cfg.Wrapper),
+		wrappers:          cfg.wrappers(),
+		toolManager:       firstString(cfg.ToolManager, "mise"),
+		kubeContext:       cfg.KubeContext,
+		kubeNamespace:     cfg.KubeNamespace,
+		only:              o.Only,
+		applySuccessful:   o.ApplySuccessful,
+		highlight:         o.Highlight || cfg.Highlight,
+	}
+
+	opts.beforeBlock, opts.afterBlock = blockHooks(cfg.Hooks, o)
+
+	if o.Match != "" {
+		opts.match, err = regexp.Compile(o.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --match: %w", err)
+		}
+	}
+
+	path, err := prependPath(append(o.PathPrepend, cfg.PathPrepend...))
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		opts.env = append(opts.env, path)
+	}
+
+	opts.maxOutput, err = parseSize(firstString(o.MaxOutput, cfg.MaxOutput, defaultMaxOutput))
+	if err != nil {
+		return nil, fmt.Errorf("invalid max output: %w", err)
+	}
+
+	// check the names up front rather than after running some blocks
+	if _, err := selectNormalizers(opts.normalize, opts.normalizers); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
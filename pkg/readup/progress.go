@@ -0,0 +1,37 @@
+package readup
+
+import (
+	"fmt"
+	"time"
+)
+
+// progress reports how far through a run readup is, so a run with many
+// blocks doesn't look stuck while a slow one runs.
+type progress struct {
+	total int
+	done  int
+	start time.Time
+	// report, if set, is also told about each block, see
+	// options.progress
+	report func(done, total, line int)
+	// quiet stops progress being printed, see options.quiet
+	quiet bool
+}
+
+func newProgress(total int) *progress {
+	return &progress{total: total, start: time.Now()}
+}
+
+// next() announces that the block at filename:line is about to run.
+func (p *progress) next(filename string, line int) {
+	p.done++
+	if p.report != nil {
+		p.report(p.done, p.total, line)
+	}
+	// a single block's "Running:" line is progress enough
+	if p.total < 2 || p.quiet {
+		return
+	}
+	fmt.Println(paint(colors.label, fmt.Sprintf("[%d/%d, %s elapsed] %s:%d",
+		p.done, p.total, time.Since(p.start).Round(100*time.Millisecond), filename, line)))
+}
@@ -0,0 +1,51 @@
+package readup
+
+import "regexp"
+
+// In the diff of a whole document, the unchanged lines around a change
+// are mostly the document's prose, and its headings are the quickest way
+// to tell which section a hunk is in. So context lines that are Markdown
+// text, rather than part of a code block, are lightly rendered: headings
+// in the heading style, and *emphasis* and **strong emphasis** in the
+// palette's emphasis and strong styles. The markup itself is left in
+// place, so the diff still reads as a diff of the file.
+
+var (
+	headingPattern  = regexp.MustCompile(`^ {0,3}#{1,6}(\s|$)`)
+	emphasisPattern = regexp.MustCompile(`\*\*[^*\s](?:[^*]*[^*\s])?\*\*|\b__[^_\s](?:[^_]*[^_\s])?__\b|\*[^*\s](?:[^*]*[^*\s])?\*|\b_[^_\s](?:[^_]*[^_\s])?_\b`)
+)
+
+// proseLines() returns the numbers, from 1, of the lines of the Markdown
+// document content that are text rather than part of a code block.
+func proseLines(content string) map[int]bool {
+	prose := map[int]bool{}
+	lineNo := 0
+	for _, n := range parseDocument([]byte(content)).nodes {
+		if n.block == nil {
+			lineNo++
+			prose[lineNo] = true
+			continue
+		}
+		// a hidden command's lines were taken from the text before the
+		// fence, so count from the fence
+		lineNo = n.block.line + len(n.block.body)
+		if n.block.closing != "" {
+			lineNo++
+		}
+	}
+	return prose
+}
+
+// renderMarkdown() returns a line of Markdown text with its heading or
+// emphasis styled.
+func renderMarkdown(line string) string {
+	if headingPattern.MatchString(line) {
+		return paint(colors.heading, line)
+	}
+	return emphasisPattern.ReplaceAllStringFunc(line, func(s string) string {
+		if len(s) > 4 && (s[:2] == "**" || s[:2] == "__") {
+			return paint(colors.strong, s)
+		}
+		return paint(colors.emphasis, s)
+	})
+}
@@ -0,0 +1,293 @@
+package readup
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reports describe each block's status in a format CI systems already
+// know how to display. In every format a block is up to date if running
+// its command didn't change its output. Blocks that failed, which with
+// --apply-successful don't stop the run, are errors in a JUnit report.
+
+var reportFormats = []string{"junit", "codequality", "json"}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr,omitempty"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// codeQualityIssue is an entry in a GitLab code quality report.
+type codeQualityIssue struct {
+	Description string              `json:"description"`
+	CheckName   string              `json:"check_name"`
+	Fingerprint string              `json:"fingerprint"`
+	Severity    string              `json:"severity"`
+	Location    codeQualityLocation `json:"location"`
+}
+
+type codeQualityLocation struct {
+	Path  string           `json:"path"`
+	Lines codeQualityLines `json:"lines"`
+}
+
+type codeQualityLines struct {
+	Begin int `json:"begin"`
+}
+
+// jsonBlock is a block's entry in a JSON report.
+type jsonBlock struct {
+	File       string  `json:"file"`
+	Line       int     `json:"line"`
+	Command    string  `json:"command"`
+	Stale      bool    `json:"stale"`
+	ExitCode   int     `json:"exit_code"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// fileResults are the results of running one document's blocks, and
+// the failures of those that failed with applySuccessful.
+type fileResults struct {
+	filename string
+	results  []*blockResult
+	failures blockErrors
+}
+
+// writeReport() writes a report on the documents' results in the given
+// format. Documents are sorted by path and blocks by line, however they
+// were run, so a report only changes when the results do.
+func writeReport(path, format string, files []fileResults) error {
+	files = sortFileResults(files)
+
+	var data []byte
+	var err error
+	switch format {
+	case "junit":
+		data, err = junitReport(files)
+	case "codequality":
+		data, err = codeQualityReport(files)
+	case "json":
+		data, err = jsonReport(files)
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// sortFileResults() returns a copy of files sorted by path, with each
+// one's results sorted by line.
+func sortFileResults(files []fileResults) []fileResults {
+	sorted := make([]fileResults, len(files))
+	for i, file := range files {
+		results := append([]*blockResult{}, file.results...)
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].line < results[j].line
+		})
+		sorted[i] = fileResults{filename: file.filename, results: results, failures: file.failures}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return comparePaths(sorted[i].filename, sorted[j].filename) < 0
+	})
+	return sorted
+}
+
+// comparePaths() orders paths a directory at a time, so a directory's
+// files stay together: docs/x/a.md comes before docs-old/a.md.
+func comparePaths(a, b string) int {
+	as := strings.Split(filepath.ToSlash(a), "/")
+	bs := strings.Split(filepath.ToSlash(b), "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	return len(as) - len(bs)
+}
+
+func junitReport(files []fileResults) ([]byte, error) {
+	suites := junitTestSuites{Suites: []junitTestSuite{}}
+	for _, file := range files {
+		suite := junitTestSuite{Name: file.filename, Tests: len(file.results)}
+		for _, result := range file.results {
+			testCase := junitTestCase{
+				Name:      fmt.Sprintf("line %d: %s", result.line, result.command),
+				ClassName: file.filename,
+				Time:      fmt.Sprintf("%.3f", result.duration.Seconds()),
+			}
+			if result.stale() {
+				suite.Failures++
+				testCase.Failure = &junitFailure{
+					Message: "output is out of date",
+					Text:    fmt.Sprintf("expected:\n%s\nfound:\n%s\n", result.output, result.previous),
+				}
+			}
+			suite.Cases = append(suite.Cases, testCase)
+		}
+		// blocks that failed have no results, only their errors, which
+		// start with the file name
+		for _, failure := range file.failures {
+			suite.Tests++
+			suite.Errors++
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      "line " + strings.TrimPrefix(failure.Error(), file.filename+":"),
+				ClassName: file.filename,
+				Error:     &junitFailure{Message: "block failed", Text: failure.Error()},
+			})
+		}
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+func codeQualityReport(files []fileResults) ([]byte, error) {
+	issues := []codeQualityIssue{}
+	for _, file := range files {
+		for _, result := range file.results {
+			if !result.stale() {
+				continue
+			}
+
+			// the fingerprint leaves out the line so an issue is tracked
+			// across edits elsewhere in the file
+			sum := sha1.Sum([]byte(file.filename + "\x00" + result.command))
+			issues = append(issues, codeQualityIssue{
+				Description: fmt.Sprintf("Output of `%s` is out of date", result.command),
+				CheckName:   "readup-stale-block",
+				Fingerprint: hex.EncodeToString(sum[:]),
+				Severity:    "minor",
+				Location: codeQualityLocation{
+					Path:  file.filename,
+					Lines: codeQualityLines{Begin: result.line},
+				},
+			})
+		}
+	}
+
+	data, err := json.MarshalIndent(issues, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func jsonReport(files []fileResults) ([]byte, error) {
+	blocks := []jsonBlock{}
+	for _, file := range files {
+		for _, result := range file.results {
+			blocks = append(blocks, jsonBlock{
+				File:       file.filename,
+				Line:       result.line,
+				Command:    result.command,
+				Stale:      result.stale(),
+				ExitCode:   result.exitCode,
+				DurationMS: float64(result.duration.Microseconds()) / 1000,
+			})
+		}
+	}
+
+	data, err := json.MarshalIndent(map[string]interface{}{"blocks": blocks}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// runMetrics summarize a run, for tracking the health of documentation
+// over many runs.
+type runMetrics struct {
+	File       string  `json:"file"`
+	Time       string  `json:"time"`
+	BlocksRun  int     `json:"blocks_run"`
+	CacheHits  int     `json:"cache_hits"`
+	Stale      int     `json:"stale"`
+	Failures   int     `json:"failures"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// writeMetrics() writes a summary of a run that produced results, or
+// failed with runErr, and took elapsed in total.
+func writeMetrics(path, filename string, results []*blockResult, runErr error, elapsed time.Duration) error {
+	metrics := runMetrics{
+		File:       filename,
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		BlocksRun:  len(results),
+		DurationMS: float64(elapsed.Microseconds()) / 1000,
+	}
+	for _, result := range results {
+		if result.cached {
+			metrics.CacheHits++
+		}
+		if result.stale() {
+			metrics.Stale++
+		}
+	}
+	if runErr != nil {
+		// with applySuccessful each failed block is one of runErr's
+		var failures blockErrors
+		metrics.Failures = 1
+		if errors.As(runErr, &failures) {
+			metrics.Failures = len(failures)
+		}
+		metrics.Error = runErr.Error()
+	}
+
+	data, err := json.MarshalIndent(metrics, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// printTimings() lists how long each block took, slowest first, under
+// title.
+func printTimings(title string, results []*blockResult) {
+	sorted := append([]*blockResult{}, results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].duration > sorted[j].duration
+	})
+
+	fmt.Println(paint(colors.heading, title))
+	for _, result := range sorted {
+		fmt.Printf("  %10s  line %d: %s\n", result.duration.Round(time.Millisecond), result.line, result.command)
+	}
+}
@@ -0,0 +1,108 @@
+package readup
+
+import "net/http"
+
+// The review page is served by `readup serve --ui`. It runs files with
+// the serve API, lists the blocks whose output changed with the old and
+// new output, and applies the changes to the blocks that are accepted,
+// leaving the rejected ones as they were.
+
+const reviewHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>readup review</title>
+<style>
+body { font: 14px sans-serif; max-width: 980px; margin: 0 auto; padding: 16px; color: #1f2328; }
+.block { border: 1px solid #d0d7de; border-radius: 6px; margin: 8px 0; }
+.block header { padding: 8px; background: #f6f8fa; border-bottom: 1px solid #d0d7de; }
+.block pre { margin: 0; padding: 8px; overflow-x: auto; }
+.removed { background: #ffebe9; }
+.added { background: #dafbe1; }
+code { font-size: 13px; }
+</style>
+</head>
+<body>
+<h1>readup review</h1>
+<form id="run">
+<input id="file" value="README.md" size="40">
+<button type="submit">Run</button>
+<span id="status"></span>
+</form>
+<div id="files"></div>
+<script>
+const status = document.getElementById("status");
+
+async function call(method, path) {
+  const resp = await fetch(path, {method: method});
+  const result = await resp.json();
+  if (!resp.ok) throw new Error(result.error);
+  return result;
+}
+
+function el(tag, props, ...children) {
+  const e = Object.assign(document.createElement(tag), props);
+  e.append(...children);
+  return e;
+}
+
+async function load() {
+  const files = document.getElementById("files");
+  const {files: pending} = await call("GET", "/pending");
+  files.replaceChildren();
+  for (const [file, blocks] of Object.entries(pending).sort()) {
+    const boxes = [];
+    const section = el("section", {}, el("h2", {}, file));
+    if (blocks.length === 0) section.append(el("p", {}, "No blocks changed."));
+    for (const block of blocks) {
+      const box = el("input", {type: "checkbox", checked: true, value: block.line});
+      boxes.push(box);
+      section.append(el("div", {className: "block"},
+        el("header", {}, el("label", {}, box, " accept line " + block.line + ": ", el("code", {}, block.command))),
+        el("pre", {className: "removed"}, block.previous),
+        el("pre", {className: "added"}, block.output)));
+    }
+    const apply = async (lines) => {
+      try {
+        await call("POST", "/apply?file=" + encodeURIComponent(file) + "&lines=" + lines.join(","));
+        status.textContent = "Updated " + file;
+      } catch (e) {
+        status.textContent = e.message;
+      }
+      load();
+    };
+    section.append(
+      el("button", {onclick: () => apply(boxes.filter(b => b.checked).map(b => b.value))}, "Apply accepted"), " ",
+      el("button", {onclick: () => apply([])}, "Reject all"));
+    files.append(section);
+  }
+}
+
+document.getElementById("run").onsubmit = async (event) => {
+  event.preventDefault();
+  const file = document.getElementById("file").value;
+  status.textContent = "Running " + file + "...";
+  try {
+    await call("POST", "/run?file=" + encodeURIComponent(file));
+    status.textContent = "";
+  } catch (e) {
+    status.textContent = e.message;
+  }
+  load();
+};
+
+load();
+</script>
+</body>
+</html>
+`
+
+// reviewPage() serves the review page.
+func reviewPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(reviewHTML))
+}
@@ -0,0 +1,244 @@
+package readup
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// runScriptBlock() runs a script block, putting its output in output.
+func runScriptBlock(block, output *codeBlock, opts *options) (*blockResult, error) {
+	command, err := scriptCommand(block.lang, block.content(), opts.interpreters)
+	if err != nil {
+		return nil, err
+	}
+	// the command feeds the script to its interpreter with a heredoc,
+	// which needs a POSIX shell whatever the block's shell is
+	attrs := blockAttrs{}
+	for key, value := range block.attrs {
+		attrs[key] = value
+	}
+	attrs["shell"] = defaultShell
+
+	result, err := runBlock(command, output.lang, attrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	result.previous = strings.Join(output.content(), "\n")
+	if err := keepUnchanged(result, block.attrs, opts); err != nil {
+		return nil, err
+	}
+	output.setOutput(0, result.output)
+	return result, nil
+}
+
+// runCommandBlock() runs a '> [command]' block and replaces the rest of
+// the block with the output.
+func runCommandBlock(block *codeBlock, opts *options) (*blockResult, error) {
+	lines := block.commandLines()
+	command, headerLines, err := parseCommand(lines)
+	if err != nil {
+		return nil, err
+	}
+	style, err := echoStyle(block, opts)
+	if err != nil {
+		return nil, err
+	}
+	result, err := runBlock(command, block.lang, commandAttrs(block), opts)
+	if err != nil {
+		return nil, err
+	}
+	result.previous = strings.Join(lines[headerLines:], "\n")
+	if err := keepUnchanged(result, block.attrs, opts); err != nil {
+		return nil, err
+	}
+
+	// Replace the code block with the output of the command
+	n, err := block.setCommand(lines[:headerLines], headerLines, style)
+	if err != nil {
+		return nil, err
+	}
+	block.setOutput(n, result.output)
+	return result, nil
+}
+
+// commandAttrs() returns the attributes to run block's commands with,
+// which for a block in a language like PowerShell include its shell.
+func commandAttrs(block *codeBlock) blockAttrs {
+	shell, ok := languageShells[strings.ToLower(block.lang)]
+	if !ok || block.attrs["shell"] != "" {
+		return block.attrs
+	}
+
+	attrs := blockAttrs{"shell": shell}
+	for key, value := range block.attrs {
+		attrs[key] = value
+	}
+	return attrs
+}
+
+// echoStyle() returns how block shows its command.
+func echoStyle(block *codeBlock, opts *options) (string, error) {
+	style := block.attrs.string("echo", opts.echo)
+	if !contains(echoStyles, style) {
+		return "", fmt.Errorf("unknown echo style %q (available: %s)", style, strings.Join(echoStyles, ", "))
+	}
+	return style, nil
+}
+
+// runBlock() produces the output to insert for a block running command,
+// either by running it or, in replay and offline modes, from the recorded
+// output. lang is the language the block declares its output in.
+func runBlock(command, lang string, attrs blockAttrs, opts *options) (*blockResult, error) {
+	normalizers, err := selectNormalizers(
+		append(opts.normalize, attrs.list("normalize")...), opts.normalizers)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &blockResult{command: command}
+	if opts.replay != nil {
+		var found bool
+		result.output, found = opts.replay.lookup(command)
+		if !found {
+			return nil, fmt.Errorf("no recorded output for command %q, run readup record first", command)
+		}
+		result.cached = true
+	} else if opts.offline != nil && (opts.offlineAll || attrs.bool("network", false)) {
+		var found bool
+		result.output, found = opts.offline.lookup(command)
+		if !found {
+			return nil, fmt.Errorf("can't run command %q offline and no recorded output exists, run readup record first", command)
+		}
+		result.cached = true
+		if !opts.quiet {
+			fmt.Printf("Offline, using recorded output for: %s\n", command)
+		}
+	} else {
+		eo, err := blockExecOptions(attrs, opts)
+		if err != nil {
+			return nil, err
+		}
+		result.tools, err = checkToolVersions(command, attrs, eo, opts)
+		if err != nil {
+			return nil, err
+		}
+		eo.highlight = highlightLanguage(lang, attrs, opts)
+
+		if _, ok := attrs["benchmark"]; ok {
+			runs, err := attrs.int("benchmark", 0)
+			if err != nil {
+				return nil, err
+			}
+			result.output, result.duration, err = runBenchmark(command, runs, eo)
+			if err != nil {
+				return nil, err
+			}
+			if opts.record != nil {
+				opts.record.add(command, result.output)
+			}
+			return result, nil
+		}
+
+		wrap, err := attrs.int("wrap", opts.wrap)
+		if err != nil {
+			return nil, err
+		}
+		tabWidth, err := attrs.int("expand-tabs", opts.expandTabs)
+		if err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		output, exitCode, err := execCommand(command, eo)
+		if err != nil {
+			return nil, err
+		}
+
+		result.exitCode = exitCode
+		result.duration = time.Since(start)
+		expectFail := attrs.bool("expect-fail", false)
+		if exitCode != 0 && !expectFail {
+			return nil, fmt.Errorf("command %q exited with status %d, mark the block expect-fail=true if that's intended", command, exitCode)
+		}
+		if exitCode == 0 && expectFail {
+			return nil, fmt.Errorf("command %q was expected to fail but succeeded", command)
+		}
+
+		output = normalize(output, normalizers)
+		output = expandTabs(output, tabWidth)
+		output = softWrap(output, wrap)
+		if attrs.bool("trim-trailing-space", opts.trimTrailingSpace) {
+			output = trimTrailingSpace(output)
+		}
+		if attrs.bool("trim-blank-lines", opts.trimBlankLines) {
+			output = trimBlankLines(output)
+		}
+		if attrs.bool("show-exit-status", false) {
+			output = appendLine(output, fmt.Sprintf("# exit status: %d", exitCode))
+		}
+		if attrs.bool("show-time", false) {
+			output = appendLine(output, fmt.Sprintf("# took %s", result.duration.Round(10*time.Millisecond)))
+		}
+		result.output = output
+	}
+
+	if opts.record != nil {
+		opts.record.add(command, result.output)
+	}
+	return result, nil
+}
+
+// umaskPattern matches the umasks a block can set.
+var umaskPattern = regexp.MustCompile(`^0?[0-7]{3}$`)
+
+// blockExecOptions() returns how to run a block's command, from its
+// attributes and the run's options.
+func blockExecOptions(attrs blockAttrs, opts *options) (execOptions, error) {
+	columns, err := attrs.int("columns", opts.columns)
+	if err != nil {
+		return execOptions{}, err
+	}
+	lines, err := attrs.int("lines", opts.lines)
+	if err != nil {
+		return execOptions{}, err
+	}
+	capture := attrs.string("capture", opts.capture)
+	if !contains(captureModes, capture) {
+		return execOptions{}, fmt.Errorf("unknown capture mode %q (available: %s)", capture, strings.Join(captureModes, ", "))
+	}
+	// tty=false is the same as capture=pipe, tty=true uses a PTY even if
+	// the default is to capture from a pipe
+	if _, ok := attrs["tty"]; ok {
+		if !attrs.bool("tty", true) {
+			capture = "pipe"
+		} else if capture == "pipe" {
+			capture = "pty"
+		}
+	}
+	umask := attrs["umask"]
+	if umask != "" && !umaskPattern.MatchString(umask) {
+		return execOptions{}, fmt.Errorf("umask %q must be octal, e.g. 022", umask)
+	}
+	eo := execOptions{
+		baseEnv:   opts.baseEnv,
+		env:       opts.env,
+		maxOutput: opts.maxOutput,
+		print:     !opts.quiet,
+		term:      attrs.string("term", opts.term),
+		columns:   columns,
+		lines:     lines,
+		capture:   capture,
+		shell:     attrs.string("shell", opts.shell),
+		umask:     umask,
+		dir:       opts.dir,
+
+		noControllingTerminal: !attrs.bool("controlling-terminal", true),
+	}
+	eo.runner, err = blockRunner(attrs, opts, &eo)
+	if err != nil {
+		return execOptions{}, err
+	}
+	return eo, nil
+}
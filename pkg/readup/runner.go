@@ -0,0 +1,211 @@
+package readup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// A block's command normally runs on this machine, but some attributes
+// run it somewhere else by prefixing the shell with a runner command,
+// e.g. `wsl.exe -d Ubuntu -e /bin/sh -c <command>`, in a running
+// container with container=<name>, or in a Kubernetes pod with
+// pod=<name> (or anything else kubectl exec accepts, like
+// deployment/<name>). Blocks run here can instead run in the project's
+// pinned toolchain, with a wrapper like `nix develop -c`, or with the
+// tool versions they list in tools=node@20,python@3.12, or with a
+// Python virtualenv activated by venv=.venv, a path relative to the
+// document's directory.
+
+// containerEngines are the tools container= can exec with.
+var containerEngines = []string{"docker", "podman"}
+
+// blockRunner() returns the runner command for a block's attributes,
+// or nil to run it directly. eo is how the command would run locally,
+// and gets any variables the runner needs.
+func blockRunner(attrs blockAttrs, opts *options, eo *execOptions) ([]string, error) {
+	if container, ok := attrs["container"]; ok {
+		engine := attrs.string("container-engine", opts.containerEngine)
+		if !contains(containerEngines, engine) {
+			return nil, fmt.Errorf("unknown container engine %q (available: %s)", engine, strings.Join(containerEngines, ", "))
+		}
+		return append([]string{engine, "exec", "-i"}, append(remoteExecFlags(*eo), container)...), nil
+	}
+
+	if pod, ok := attrs["pod"]; ok {
+		runner := []string{"kubectl", "exec", "-i"}
+		if eo.capture != "pipe" {
+			runner = append(runner, "-t")
+		}
+		if opts.kubeContext != "" {
+			runner = append(runner, "--context", opts.kubeContext)
+		}
+		if namespace := attrs.string("namespace", opts.kubeNamespace); namespace != "" {
+			runner = append(runner, "-n", namespace)
+		}
+		if container := attrs["pod-container"]; container != "" {
+			runner = append(runner, "-c", container)
+		}
+		// kubectl exec can't set variables, so env does it in the pod
+		runner = append(runner, pod, "--")
+		if env := remoteEnv(*eo); len(env) > 0 {
+			runner = append(append(runner, "env"), env...)
+		}
+		return runner, nil
+	}
+
+	if distro, ok := attrs["wsl"]; ok {
+		if runtime.GOOS != "windows" {
+			return nil, fmt.Errorf("wsl=%s only works when readup runs on Windows", distro)
+		}
+		runner := []string{"wsl.exe"}
+		// wsl=true uses the default distribution
+		if distro != "" && distro != "true" {
+			runner = append(runner, "-d", distro)
+		}
+		return append(runner, "-e"), nil
+	}
+
+	runner, err := environmentWrapper(attrs.string("wrapper", opts.wrapper), opts.wrappers)
+	if err != nil {
+		return nil, err
+	}
+
+	if tools := attrs.list("tools"); len(tools) > 0 {
+		pinned, env, err := pinTools(tools, opts.toolManager)
+		if err != nil {
+			return nil, err
+		}
+		runner = append(pinned, runner...)
+		eo.env = append(append([]string{}, eo.env...), env...)
+	}
+
+	if venv := attrs["venv"]; venv != "" {
+		env, err := activateVenv(venv, eo.dir, eo.env)
+		if err != nil {
+			return nil, err
+		}
+		eo.env = append(append([]string{}, eo.env...), env...)
+	}
+	return runner, nil
+}
+
+// activateVenv() returns the variables that activate the Python
+// virtualenv in dir, relative to the block's directory base, the way
+// its activate script would: VIRTUAL_ENV, and PATH with the
+// virtualenv's scripts in front of the PATH in env.
+func activateVenv(dir, base string, env []string) ([]string, error) {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(base, dir)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	bin := filepath.Join(dir, "bin")
+	if runtime.GOOS == "windows" {
+		bin = filepath.Join(dir, "Scripts")
+	}
+	if _, err := os.Stat(bin); err != nil {
+		return nil, fmt.Errorf("venv=%s isn't a virtualenv: %w", dir, err)
+	}
+
+	path := os.Getenv("PATH")
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			path = strings.TrimPrefix(kv, "PATH=")
+		}
+	}
+	if path != "" {
+		bin += string(os.PathListSeparator) + path
+	}
+	return []string{"VIRTUAL_ENV=" + dir, "PATH=" + bin}, nil
+}
+
+// toolManagers are the tools that can pin the versions given by a
+// block's tools attribute.
+var toolManagers = []string{"mise", "asdf"}
+
+// pinTools() returns the runner and variables that make tools, like
+// node@20, the versions a command runs with. mise can run a command
+// with them directly, while asdf reads a variable for each tool.
+func pinTools(tools []string, manager string) ([]string, []string, error) {
+	for _, tool := range tools {
+		if name, version, ok := strings.Cut(tool, "@"); !ok || name == "" || version == "" {
+			return nil, nil, fmt.Errorf("tools entry %q must be name@version", tool)
+		}
+	}
+
+	switch manager {
+	case "mise":
+		return append(append([]string{"mise", "exec"}, tools...), "--"), nil, nil
+	case "asdf":
+		var env []string
+		for _, tool := range tools {
+			name, version, _ := strings.Cut(tool, "@")
+			name = strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+			env = append(env, fmt.Sprintf("ASDF_%s_VERSION=%s", name, version))
+		}
+		return nil, env, nil
+	}
+	return nil, nil, fmt.Errorf("unknown tool manager %q (available: %s)", manager, strings.Join(toolManagers, ", "))
+}
+
+// defaultWrappers are the environment wrappers known by name, on top of
+// the config's wrappers.
+var defaultWrappers = map[string]string{
+	"nix":    "nix develop -c",
+	"devbox": "devbox run --",
+}
+
+// environmentWrapper() returns the command that sets up the environment
+// for a block, from the name of a wrapper or the command itself. "" or
+// "none" runs blocks without one.
+func environmentWrapper(wrapper string, wrappers map[string]string) ([]string, error) {
+	if wrapper == "" || wrapper == "none" {
+		return nil, nil
+	}
+	if command, ok := wrappers[wrapper]; ok {
+		wrapper = command
+	} else if !strings.Contains(wrapper, " ") {
+		return nil, fmt.Errorf("unknown wrapper %q, add it to the config's wrappers", wrapper)
+	}
+	return strings.Fields(wrapper), nil
+}
+
+// remoteExecFlags() returns the `docker exec` style flags that give a
+// command run elsewhere a terminal if it would have one here, and the
+// block's environment, which isn't inherited from readup there.
+func remoteExecFlags(eo execOptions) []string {
+	var flags []string
+	if eo.capture != "pipe" {
+		flags = append(flags, "-t")
+	}
+	for _, kv := range remoteEnv(eo) {
+		flags = append(flags, "-e", kv)
+	}
+	return flags
+}
+
+// remoteEnv() returns the variables a command run elsewhere should get.
+// PATH is left out, since this machine's directories mean nothing there.
+func remoteEnv(eo execOptions) []string {
+	var env []string
+	for _, kv := range eo.env {
+		if !strings.HasPrefix(kv, "PATH=") {
+			env = append(env, kv)
+		}
+	}
+	if eo.term != "" {
+		env = append(env, "TERM="+eo.term)
+	}
+	if eo.columns != 0 {
+		env = append(env, fmt.Sprintf("COLUMNS=%d", eo.columns))
+	}
+	if eo.lines != 0 {
+		env = append(env, fmt.Sprintf("LINES=%d", eo.lines))
+	}
+	return env
+}
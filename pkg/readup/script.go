@@ -0,0 +1,53 @@
+package readup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A block fenced with e.g. "```bash readup=script" is run as a whole by
+// the interpreter for its language, rather than just a "> " command on
+// its first line. The script itself is left alone, and its output goes
+// in a block fenced with "```text readup=output" directly after it,
+// which readup adds the first time and replaces after that.
+
+const (
+	scriptOutputFence = "```text readup=output"
+
+	// scriptDelimiter ends the heredoc that feeds a script to its
+	// interpreter
+	scriptDelimiter = "READUP_SCRIPT_EOF"
+)
+
+// defaultInterpreters map a fence language to a command that reads a
+// program from stdin. The config's interpreters are added to these.
+var defaultInterpreters = map[string]string{
+	"sh":         "sh -s",
+	"bash":       "bash -s",
+	"zsh":        "zsh -s",
+	"python":     "python3 -",
+	"python3":    "python3 -",
+	"ruby":       "ruby -",
+	"perl":       "perl -",
+	"node":       "node -",
+	"js":         "node -",
+	"javascript": "node -",
+}
+
+// scriptCommand() returns a shell command that runs script with the
+// interpreter for lang.
+func scriptCommand(lang string, script []string, interpreters map[string]string) (string, error) {
+	interpreter, ok := interpreters[lang]
+	if !ok {
+		return "", fmt.Errorf("no interpreter for script language %q, add one to the config's interpreters", lang)
+	}
+
+	for _, line := range script {
+		if line == scriptDelimiter {
+			return "", fmt.Errorf("script can't contain the line %s", scriptDelimiter)
+		}
+	}
+
+	return fmt.Sprintf("%s <<'%s'\n%s\n%s",
+		interpreter, scriptDelimiter, strings.Join(script, "\n"), scriptDelimiter), nil
+}
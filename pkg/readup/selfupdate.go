@@ -0,0 +1,227 @@
+package readup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// `readup self-update` replaces the running binary with the one from the
+// latest GitHub release, for installs that didn't come from a package
+// manager. A release has a binary per platform, named like
+// readup-linux-amd64, and a checksums.txt listing their SHA-256 sums in
+// the format written by sha256sum.
+//
+// The binary is only replaced by a newer release, going by semantic
+// versioning, and never if it isn't itself a release, like a dev build
+// or one versioned by git describe, since there's no telling whether
+// the release is newer. --force replaces it anyway.
+
+const releaseRepo = "bakks/readup"
+
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// releaseAsset() returns the name of the release binary for this
+// platform.
+func releaseAsset() string {
+	name := fmt.Sprintf("readup-%s-%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// releaseVersionPattern matches release versions like v1.2.3 and
+// 1.3.0-rc.1, and not dev builds, git describe versions like
+// v1.2.3-4-gabcdef0, or Go's pseudo-versions.
+var releaseVersionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z]+(?:\.[0-9A-Za-z]+)*))?$`)
+
+// selfUpdate() installs the latest release over the running binary if
+// it's newer, or whatever it is if force is set.
+func selfUpdate(force bool) error {
+	api := os.Getenv("GITHUB_API_URL")
+	if api == "" {
+		api = defaultGitHubAPI
+	}
+	api = strings.TrimSuffix(api, "/")
+
+	var release githubRelease
+	url := fmt.Sprintf("%s/repos/%s/releases/latest", api, releaseRepo)
+	if err := githubRequest("GET", url, os.Getenv("GITHUB_TOKEN"), nil, &release); err != nil {
+		return err
+	}
+	current, _, _ := buildInfo()
+	if !force {
+		if !releaseVersionPattern.MatchString(release.TagName) {
+			return fmt.Errorf("the latest release, %s, isn't versioned like a release, use --force to install it anyway", release.TagName)
+		}
+		if !releaseVersionPattern.MatchString(current) {
+			return fmt.Errorf("readup %s isn't a release build, use --force to replace it with %s", current, release.TagName)
+		}
+		if compareSemver(release.TagName, current) <= 0 {
+			fmt.Printf("readup %s is up to date, the latest release is %s\n", current, release.TagName)
+			return nil
+		}
+	}
+
+	assets := map[string]string{}
+	for _, asset := range release.Assets {
+		assets[asset.Name] = asset.URL
+	}
+	name := releaseAsset()
+	if assets[name] == "" {
+		return fmt.Errorf("release %s has no binary for %s/%s", release.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	if assets["checksums.txt"] == "" {
+		return fmt.Errorf("release %s has no checksums.txt, refusing to install it unverified", release.TagName)
+	}
+
+	checksums, err := download(assets["checksums.txt"])
+	if err != nil {
+		return err
+	}
+	want, err := findChecksum(checksums, name)
+	if err != nil {
+		return fmt.Errorf("release %s: %w", release.TagName, err)
+	}
+
+	fmt.Printf("Downloading readup %s\n", release.TagName)
+	binary, err := download(assets[name])
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(binary)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("%s has checksum %s but checksums.txt says %s, not installing it", name, got, want)
+	}
+
+	if err := replaceExecutable(binary); err != nil {
+		return err
+	}
+	fmt.Printf("Updated readup %s to %s\n", current, release.TagName)
+	return nil
+}
+
+// compareSemver() compares the release versions a and b, which
+// releaseVersionPattern matches, returning -1, 0 or 1 as a is older,
+// the same or newer. A pre-release is older than its release.
+func compareSemver(a, b string) int {
+	if c := compareVersions(a, b); c != 0 {
+		return c
+	}
+	x := releaseVersionPattern.FindStringSubmatch(a)[4]
+	y := releaseVersionPattern.FindStringSubmatch(b)[4]
+	switch {
+	case x == y:
+		return 0
+	case x == "":
+		return 1
+	case y == "":
+		return -1
+	}
+
+	p, q := strings.Split(x, "."), strings.Split(y, ".")
+	for i := 0; i < len(p) && i < len(q); i++ {
+		if c := comparePrerelease(p[i], q[i]); c != 0 {
+			return c
+		}
+	}
+	return compareVersions(strconv.Itoa(len(p)), strconv.Itoa(len(q)))
+}
+
+// comparePrerelease() compares identifiers of pre-release versions:
+// numbers by their value, and before anything else, which is compared
+// by ASCII.
+func comparePrerelease(a, b string) int {
+	_, errA := strconv.Atoi(a)
+	_, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return compareVersions(a, b)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// findChecksum() returns the checksum for name from a checksums file.
+func findChecksum(checksums []byte, name string) (string, error) {
+	for _, line := range strings.Split(string(checksums), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("checksums.txt has no checksum for %s", name)
+}
+
+func download(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("downloading %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// replaceExecutable() swaps the running binary for binary. The new one
+// is written next to it first, so the swap is a rename and a failed
+// download never leaves a half-written binary behind.
+func replaceExecutable(binary []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".readup-update")
+	if err != nil {
+		return fmt.Errorf("can't write next to %s: %w", exe, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, bytes.NewReader(binary))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+
+	// Windows won't replace a running executable, but will rename it
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), exe)
+}
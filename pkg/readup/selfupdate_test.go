@@ -0,0 +1,44 @@
+package readup
+
+import "testing"
+
+func TestReleaseVersionPattern(t *testing.T) {
+	for version, want := range map[string]bool{
+		"v1.2.3":                               true,
+		"1.2.3":                                true,
+		"v1.3.0-rc.1":                          true,
+		"dev":                                  false,
+		"v1.2.3-4-gabcdef0":                    false,
+		"v1.2.3-4-gabcdef0-dirty":              false,
+		"v0.0.0-20230101120000-abcdef123456":   false,
+		"v1.2.4-0.20230101120000-abcdef123456": false,
+		"v1.2":                                 false,
+	} {
+		if got := releaseVersionPattern.MatchString(version); got != want {
+			t.Errorf("releaseVersionPattern matching %q = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestCompareSemver(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.3", "1.2.3", 0},
+		{"v1.2.4", "v1.2.3", 1},
+		{"v1.10.0", "v1.9.9", 1},
+		{"v1.2.3", "v2.0.0", -1},
+		{"v1.3.0-rc.1", "v1.3.0", -1},
+		{"v1.3.0", "v1.3.0-rc.1", 1},
+		{"v1.3.0-rc.2", "v1.3.0-rc.10", -1},
+		{"v1.3.0-alpha", "v1.3.0-beta", -1},
+		{"v1.3.0-1", "v1.3.0-alpha", -1},
+		{"v1.3.0-rc", "v1.3.0-rc.1", -1},
+	}
+	for _, tt := range tests {
+		if got := compareSemver(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareSemver(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
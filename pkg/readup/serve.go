@@ -0,0 +1,377 @@
+package readup
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// `readup serve` is a small HTTP API on the local machine, for editor
+// plugins, dashboards and scripts:
+//
+//	GET  /blocks?file=README.md             the blocks that would be run
+//	POST /run?file=README.md[&line=N]       run every block, or the one at line N
+//	GET  /diff?file=README.md               the diff the last run would make
+//	POST /apply?file=README.md[&lines=N,M]  write the last run's changes, or only those to some blocks
+//	GET  /pending                           the files with changes, and their stale blocks
+//
+// With --ui a page at / uses the API to review the pending changes, and
+// accept or reject each block's.
+//
+// Files are named relative to the directory readup serves, and are only
+// parsed again once they change. Since the API runs commands, requests
+// from web pages on other sites are refused, as are requests for a host
+// name other than the one readup listens on or localhost, which is how
+// a site whose name has been pointed at 127.0.0.1 would reach it.
+
+// defaultListen is where readup serve listens if --listen isn't given.
+const defaultListen = "127.0.0.1:7331"
+
+// apiServer is the state of a `readup serve` session.
+type apiServer struct {
+	opts *options
+
+	// mu is held while handling a request, so only one runs blocks or
+	// writes a file at a time
+	mu sync.Mutex
+	// parsed are the files parsed so far
+	parsed map[string]*parsedFile
+	// pending is the last run of each file, which hasn't been applied
+	// yet
+	pending map[string]pendingRun
+}
+
+// pendingRun is the content of a file when it was run, and what the run
+// changed it to and found running each block.
+type pendingRun struct {
+	original string
+	content  string
+	results  []*blockResult
+}
+
+// parsedFile is a parsed file, and the modification time and size it
+// had then.
+type parsedFile struct {
+	modTime time.Time
+	size    int64
+	data    []byte
+	doc     *document
+}
+
+// apiBlock is a block in the response to /blocks.
+type apiBlock struct {
+	Line    int    `json:"line"`
+	ID      string `json:"id,omitempty"`
+	Command string `json:"command"`
+}
+
+// apiStaleBlock is a block whose output a run changed, in the response
+// to /pending.
+type apiStaleBlock struct {
+	Line     int    `json:"line"`
+	Command  string `json:"command"`
+	Previous string `json:"previous"`
+	Output   string `json:"output"`
+}
+
+// serve() serves the API on addr until it fails, and the review page if
+// ui is set.
+func serve(addr string, ui bool, opts *options) error {
+	s := &apiServer{opts: opts, parsed: map[string]*parsedFile{}, pending: map[string]pendingRun{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blocks", s.handler(http.MethodGet, s.blocks))
+	mux.HandleFunc("/run", s.handler(http.MethodPost, s.run))
+	mux.HandleFunc("/diff", s.handler(http.MethodGet, s.diff))
+	mux.HandleFunc("/apply", s.handler(http.MethodPost, s.apply))
+	mux.HandleFunc("/pending", s.pendingChanges)
+	if ui {
+		mux.HandleFunc("/", reviewPage)
+	}
+
+	fmt.Printf("Serving the readup API on http://%s\n", addr)
+	return http.ListenAndServe(addr, localOnly(addr, mux))
+}
+
+// handler() wraps an API endpoint taking a file, which writes its result
+// as JSON, or an error as {"error": "..."}.
+func (s *apiServer) handler(method string, endpoint func(filename string, r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		status := http.StatusOK
+		var result interface{}
+
+		filename, err := apiFilename(r.URL.Query().Get("file"))
+		switch {
+		case r.Method != method:
+			status, err = http.StatusMethodNotAllowed, fmt.Errorf("%s takes %s requests", r.URL.Path, method)
+		case err != nil:
+			status = http.StatusBadRequest
+		default:
+			s.mu.Lock()
+			result, err = endpoint(filename, r)
+			s.mu.Unlock()
+			if err != nil {
+				status = http.StatusUnprocessableEntity
+			}
+		}
+		if err != nil {
+			result = map[string]string{"error": err.Error()}
+		}
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// localOnly() wraps h, served on addr, to refuse requests from web pages
+// on other sites, see sameOrigin() and localHost().
+func localOnly(addr string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !localHost(addr, r.Host):
+			http.Error(w, fmt.Sprintf("requests for %s aren't allowed", r.Host), http.StatusForbidden)
+		case !sameOrigin(r):
+			http.Error(w, fmt.Sprintf("requests from %s aren't allowed", r.Header.Get("Origin")), http.StatusForbidden)
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// sameOrigin() reports whether r comes from a page served by readup
+// itself, or from something other than a browser.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || origin == "http://"+r.Host
+}
+
+// localHost() reports whether host, from a request's Host header, names
+// readup listening on addr: addr's own host, localhost or a loopback
+// address, or if readup listens on every address, any IP address. Any
+// other name may be a site's, pointed at readup to get around
+// sameOrigin().
+func localHost(addr, host string) bool {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	listen, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		listen = addr
+	}
+	listen = strings.ToLower(strings.Trim(listen, "[]"))
+
+	switch {
+	case host == "":
+		return false
+	case host == listen || host == "localhost" || strings.HasSuffix(host, ".localhost"):
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	listenIP := net.ParseIP(listen)
+	return ip.IsLoopback() || listen == "" || listenIP != nil && listenIP.IsUnspecified()
+}
+
+// apiFilename() checks that name, from a request, is a file under the
+// directory readup serves.
+func apiFilename(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("the file parameter is required")
+	}
+	name = filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s isn't in the directory readup serves", name)
+	}
+	return name, nil
+}
+
+// parse() returns filename parsed, parsing it again only if it's
+// changed since it was last parsed.
+func (s *apiServer) parse(filename string) (*parsedFile, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := s.parsed[filename]; ok && p.modTime.Equal(info.ModTime()) && p.size == info.Size() {
+		return p, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	p := &parsedFile{modTime: info.ModTime(), size: info.Size(), data: data, doc: parseDocument(data)}
+	s.parsed[filename] = p
+	return p, nil
+}
+
+func (s *apiServer) blocks(filename string, r *http.Request) (interface{}, error) {
+	p, err := s.parse(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := []apiBlock{}
+	for _, n := range p.doc.nodes {
+		if shouldRun(n.block, s.opts) {
+			blocks = append(blocks, apiBlock{Line: n.block.line, ID: n.block.attrs["id"], Command: blockSource(n.block)})
+		}
+	}
+	return map[string]interface{}{"file": filename, "blocks": blocks}, nil
+}
+
+func (s *apiServer) run(filename string, r *http.Request) (interface{}, error) {
+	p, err := s.parse(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := *s.opts
+	if line := r.URL.Query().Get("line"); line != "" {
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %q isn't a number", line)
+		}
+		opts.changed = lineRanges{{n, n}}
+	}
+
+	content, results, err := runDocument(filename, p.data, &opts)
+	if err != nil {
+		return nil, err
+	}
+	s.pending[filename] = pendingRun{original: string(p.data), content: content, results: results}
+
+	blocks := []jsonBlock{}
+	for _, result := range results {
+		blocks = append(blocks, jsonBlock{
+			File:       filename,
+			Line:       result.line,
+			Command:    result.command,
+			Stale:      result.stale(),
+			ExitCode:   result.exitCode,
+			DurationMS: float64(result.duration.Microseconds()) / 1000,
+		})
+	}
+	return map[string]interface{}{"file": filename, "blocks": blocks, "changed": content != string(p.data)}, nil
+}
+
+func (s *apiServer) diff(filename string, r *http.Request) (interface{}, error) {
+	run, ok := s.pending[filename]
+	if !ok {
+		return nil, fmt.Errorf("%s hasn't been run yet", filename)
+	}
+
+	tmpName, err := writeTempFile(filename, run.content)
+	if err != nil {
+		return nil, err
+	}
+	defer removeTempFile(tmpName)
+
+	diff, err := unifiedDiff(diffLabel(filename), filename, tmpName)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"file": filename, "diff": diff}, nil
+}
+
+func (s *apiServer) apply(filename string, r *http.Request) (interface{}, error) {
+	run, ok := s.pending[filename]
+	if !ok {
+		return nil, fmt.Errorf("%s hasn't been run yet", filename)
+	}
+	// another readup mustn't write the file between reading it to merge
+	// and writing it here
+	if err := lockFile(filename); err != nil {
+		return nil, err
+	}
+	defer unlockFile(filename)
+
+	content := run.content
+	if r.URL.Query().Has("lines") {
+		var err error
+		content, err = s.applyBlocks(filename, run, r.URL.Query().Get("lines"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the file may have been edited since the run
+	content, err := mergeChanges(filename, run.original, content)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(filename, content); err != nil {
+		return nil, err
+	}
+	delete(s.pending, filename)
+	logEvent("write", map[string]interface{}{"file": filename})
+	return map[string]interface{}{"file": filename, "applied": true}, nil
+}
+
+// applyBlocks() returns the file as run left it, but with only the
+// changes to the blocks at lines, which is a comma-separated list. The
+// other blocks are left as they were by replaying the run's output for
+// just those blocks.
+func (s *apiServer) applyBlocks(filename string, run pendingRun, lines string) (string, error) {
+	if lines == "" {
+		return run.original, nil
+	}
+	accepted := lineRanges{}
+	store := newOutputStore()
+	for _, line := range strings.Split(lines, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(line))
+		if err != nil {
+			return "", fmt.Errorf("line %q isn't a number", line)
+		}
+
+		found := false
+		for _, result := range run.results {
+			if result.line == n {
+				store.add(result.command, result.output)
+				found = true
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("no block starting on line %d was run", n)
+		}
+		accepted = append(accepted, lineRange{n, n})
+	}
+	// nothing is run, so the run's hooks aren't called again
+	opts := *s.opts
+	opts.replay = store
+	opts.changed = accepted
+	opts.beforeBlock, opts.afterBlock = nil, nil
+	content, _, err := runDocument(filename, []byte(run.original), &opts)
+	return content, err
+}
+
+// pendingChanges() lists the files with unapplied changes, and the
+// blocks in each whose output changed.
+func (s *apiServer) pendingChanges(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	files := map[string][]apiStaleBlock{}
+	for filename, run := range s.pending {
+		blocks := []apiStaleBlock{}
+		for _, result := range run.results {
+			if result.stale() {
+				blocks = append(blocks, apiStaleBlock{Line: result.line, Command: result.command, Previous: result.previous, Output: result.output})
+			}
+		}
+		files[filename] = blocks
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"files": files})
+}
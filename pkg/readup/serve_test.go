@@ -0,0 +1,116 @@
+package readup
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalHost(t *testing.T) {
+	tests := []struct {
+		addr, host string
+		want       bool
+	}{
+		{"127.0.0.1:7331", "127.0.0.1:7331", true},
+		{"127.0.0.1:7331", "localhost:7331", true},
+		{"127.0.0.1:7331", "LOCALHOST", true},
+		{"127.0.0.1:7331", "[::1]:7331", true},
+		{"127.0.0.1:7331", "evil.example.com:7331", false},
+		{"127.0.0.1:7331", "192.168.1.10:7331", false},
+		{"127.0.0.1:7331", "", false},
+		{"devbox:7331", "devbox:7331", true},
+		{"devbox:7331", "evil.example.com:7331", false},
+		{":7331", "192.168.1.10:7331", true},
+		{":7331", "evil.example.com:7331", false},
+		{"0.0.0.0:7331", "10.0.0.2:7331", true},
+	}
+	for _, tt := range tests {
+		if got := localHost(tt.addr, tt.host); got != tt.want {
+			t.Errorf("localHost(%q, %q) = %v, want %v", tt.addr, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestLocalOnly(t *testing.T) {
+	h := localOnly("127.0.0.1:7331", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		host, origin string
+		want         int
+	}{
+		{"127.0.0.1:7331", "", http.StatusOK},
+		{"127.0.0.1:7331", "http://127.0.0.1:7331", http.StatusOK},
+		{"127.0.0.1:7331", "http://evil.example.com", http.StatusForbidden},
+		{"evil.example.com:7331", "http://evil.example.com:7331", http.StatusForbidden},
+		{"evil.example.com:7331", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/pending", nil)
+		r.Host = tt.host
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("GET /pending for %s from %q = %d, want %d", tt.host, tt.origin, w.Code, tt.want)
+		}
+	}
+}
+
+func TestApplyLocked(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "README.md")
+	if err := os.WriteFile(filename, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &apiServer{opts: &options{}, pending: map[string]pendingRun{
+		filename: {original: "old\n", content: "new\n"},
+	}}
+	r := httptest.NewRequest(http.MethodPost, "/apply", nil)
+
+	if err := lockFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.apply(filename, r); err == nil || !strings.Contains(err.Error(), "another readup") {
+		t.Errorf("apply() to a locked file = %v, want an error", err)
+	}
+	unlockFile(filename)
+
+	if _, err := s.apply(filename, r); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filename); string(data) != "new\n" {
+		t.Errorf("applied file is %q, want %q", data, "new\n")
+	}
+	if _, err := os.Stat(filename + lockSuffix); !os.IsNotExist(err) {
+		t.Errorf("apply() left the lock behind: %v", err)
+	}
+}
+
+func TestApplyBlocksSkipsHooks(t *testing.T) {
+	opts, err := newOptions(&config{}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks := 0
+	opts.beforeBlock = func(string, *codeBlock) error { hooks++; return nil }
+	opts.afterBlock = func(string, *codeBlock, []*blockResult) error { hooks++; return nil }
+	s := &apiServer{opts: opts}
+	run := pendingRun{
+		original: "```\n> echo a\nold\n```\n\n```\n> echo b\nold\n```\n",
+		results:  []*blockResult{{line: 1, command: "echo a", output: "a"}, {line: 6, command: "echo b", output: "b"}},
+	}
+
+	content, err := s.applyBlocks("README.md", run, "6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "```\n> echo a\nold\n```\n\n```\n> echo b\nb\n```\n"; content != want {
+		t.Errorf("applyBlocks() = %q, want %q", content, want)
+	}
+	if hooks != 0 {
+		t.Errorf("applyBlocks() called the block hooks %d times", hooks)
+	}
+}
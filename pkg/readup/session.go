@@ -0,0 +1,73 @@
+package readup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A block with session=true is a transcript of several commands: every
+// line starting with "> " (or "$ " with echo=dollar) is a command, and
+// the lines up to the next one are its output. Each command is run in
+// turn and its output replaced, so the block reads like a terminal
+// session.
+
+// runSessionBlock() runs each command in block and puts its output
+// beneath it, returning a result for each command.
+func runSessionBlock(block *codeBlock, opts *options) ([]*blockResult, error) {
+	style, err := echoStyle(block, opts)
+	if err != nil {
+		return nil, err
+	}
+	if style == "hidden" {
+		return nil, fmt.Errorf("a session block can't hide its commands")
+	}
+
+	lines := block.commandLines()
+	if style == "dollar" {
+		for i, line := range lines {
+			if strings.HasPrefix(line, "$ ") {
+				lines[i] = "> " + strings.TrimPrefix(line, "$ ")
+			}
+		}
+	}
+
+	var results []*blockResult
+	var content []string
+	for i := 0; i < len(lines); {
+		command, n, err := parseCommand(lines[i:])
+		if err != nil {
+			return results, err
+		}
+		header := append([]string{}, lines[i:i+n]...)
+		i += n
+
+		start := i
+		for i < len(lines) && !strings.HasPrefix(lines[i], "> ") {
+			i++
+		}
+
+		result, err := runBlock(command, block.lang, commandAttrs(block), opts)
+		if err != nil {
+			return results, err
+		}
+		// the output of each command runs right up to the next
+		result.output = strings.TrimSuffix(result.output, "\n")
+		result.previous = strings.Join(lines[start:i], "\n")
+		if err := keepUnchanged(result, block.attrs, opts); err != nil {
+			return results, err
+		}
+		results = append(results, result)
+
+		if style == "dollar" {
+			header[0] = "$ " + strings.TrimPrefix(header[0], "> ")
+		}
+		content = append(content, header...)
+		if result.output != "" {
+			content = append(content, strings.Split(result.output, "\n")...)
+		}
+	}
+
+	block.setEcho(style)
+	block.setOutput(0, strings.Join(content, "\n"))
+	return results, nil
+}
@@ -0,0 +1,100 @@
+package readup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// An outputStore holds the captured output of each block so that a
+// README can be rebuilt later without running anything. `readup record`
+// writes it and `readup replay` reads it.
+type outputStore struct {
+	Version int           `json:"version"`
+	Blocks  []storedBlock `json:"blocks"`
+
+	// seen counts lookups per command, so that a command appearing in
+	// several blocks gets each block's output in turn
+	seen map[string]int
+}
+
+type storedBlock struct {
+	Command string `json:"command"`
+	Output  string `json:"output"`
+}
+
+const storeVersion = 1
+
+// storePath() returns the default sidecar store for a README.
+func storePath(filename string) string {
+	return filename + ".readup.json"
+}
+
+func newOutputStore() *outputStore {
+	return &outputStore{Version: storeVersion}
+}
+
+// loadOutputStore() reads a store written by save().
+func loadOutputStore(filename string) (*outputStore, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &outputStore{}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if store.Version != storeVersion {
+		return nil, fmt.Errorf("%s: unsupported store version %d", filename, store.Version)
+	}
+	return store, nil
+}
+
+// loadOutputStoreIfExists() is like loadOutputStore() but returns an
+// empty store if filename doesn't exist.
+func loadOutputStoreIfExists(filename string) (*outputStore, error) {
+	store, err := loadOutputStore(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return newOutputStore(), nil
+	}
+	return store, err
+}
+
+func (s *outputStore) save(filename string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+func (s *outputStore) add(command, output string) {
+	s.Blocks = append(s.Blocks, storedBlock{Command: command, Output: output})
+}
+
+// lookup() returns the recorded output for the next block running
+// command.
+func (s *outputStore) lookup(command string) (string, bool) {
+	if s.seen == nil {
+		s.seen = map[string]int{}
+	}
+
+	n := s.seen[command]
+	for _, block := range s.Blocks {
+		if block.Command != command {
+			continue
+		}
+		if n == 0 {
+			s.seen[command]++
+			return block.Output, true
+		}
+		n--
+	}
+	return "", false
+}
@@ -0,0 +1,463 @@
+package readup
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// terminal is a minimal terminal emulator. Command output is written to
+// it and the final contents of the screen and scrollback are read back
+// with render(), so that output from tools which redraw lines, move the
+// cursor or clear parts of the screen is captured the way a user would
+// see it rather than as a raw byte stream.
+//
+// Colors and other SGR attributes are kept with each cell and re-emitted
+// when rendering. Other control sequences (window titles, cursor
+// visibility, etc.) are dropped. Characters take up as many columns as
+// they would on a real terminal (see runeWidth()), so cursor movement
+// past wide characters lands where the command meant it to. A tab moves
+// to the next tab stop, every 8 columns, but is kept in the output as a
+// tab if it only moved over blank cells.
+type terminal struct {
+	lines [][]cell
+	row   int
+	col   int
+	// attrs are the SGR attributes being written with, and style the
+	// sequence that sets them
+	attrs sgrAttrs
+	style string
+
+	savedRow, savedCol int
+}
+
+// cell is one character on the screen. A cell with r == 0 has never been
+// written (or was erased), and renders as a space unless it's trailing.
+type cell struct {
+	r     rune
+	style string
+	// marks are combining marks written after r
+	marks string
+	// right is set on the cell holding the right half of the wide
+	// character in the cell before it
+	right bool
+}
+
+// sgrAttrs are the SGR attributes text is written with. They're kept as
+// state rather than as the sequences that set them, so a command that
+// sets its colors again on every line doesn't pile them up.
+type sgrAttrs struct {
+	// on are the attributes set by the codes 1 (bold) to 9 (crossed
+	// out)
+	on [10]bool
+	// fg and bg are the codes setting the colors, e.g. "31" or
+	// "38;5;208", or "" for the default
+	fg, bg string
+}
+
+// tabWidth is the distance between tab stops.
+const tabWidth = 8
+
+// maxCursorMove is the furthest a control sequence can move the cursor,
+// and the widest a line can get, which is as wide as the widest PTY.
+const maxCursorMove = wideColumns
+
+// emulateTerminal() returns s as it would appear on a terminal.
+func emulateTerminal(s string) string {
+	t := &terminal{}
+	t.write(s)
+	return t.render()
+}
+
+func (t *terminal) line() []cell {
+	for t.row >= len(t.lines) {
+		t.lines = append(t.lines, nil)
+	}
+	return t.lines[t.row]
+}
+
+func (t *terminal) put(r rune) {
+	width := runeWidth(r)
+	line := t.line()
+	if width == 0 {
+		// a combining mark joins the character before it
+		prev := t.col - 1
+		if prev >= 0 && prev < len(line) && line[prev].right {
+			prev--
+		}
+		if prev >= 0 && prev < len(line) && line[prev].r != 0 {
+			line[prev].marks += string(r)
+			return
+		}
+		width = 1
+	}
+
+	for len(line) < t.col+width {
+		line = append(line, cell{})
+	}
+	if line[t.col].right {
+		// overwriting part of a wide character or tab erases it
+		i := t.col
+		for i > 0 && line[i].right {
+			i--
+		}
+		line[i] = cell{}
+		for i++; i < len(line) && line[i].right; i++ {
+			line[i] = cell{}
+		}
+	}
+	line[t.col] = cell{r: r, style: t.style}
+	if width == 2 {
+		line[t.col+1] = cell{style: t.style, right: true}
+	}
+	t.lines[t.row] = line
+	t.col += width
+}
+
+// tab() moves to the next tab stop, keeping the tab if the cells it
+// moves over are blank.
+func (t *terminal) tab() {
+	next := clamp((t.col/tabWidth+1)*tabWidth, 0, maxCursorMove)
+	line := t.line()
+	for i := t.col; i < next && i < len(line); i++ {
+		if line[i].r != 0 || line[i].right {
+			t.col = next
+			return
+		}
+	}
+
+	for len(line) < next {
+		line = append(line, cell{})
+	}
+	line[t.col] = cell{r: '\t', style: t.style}
+	for i := t.col + 1; i < next; i++ {
+		line[i] = cell{style: t.style, right: true}
+	}
+	t.lines[t.row] = line
+	t.col = next
+}
+
+// erase() clears columns from..to (exclusive) of the current line.
+func (t *terminal) erase(from, to int) {
+	line := t.line()
+	if to > len(line) {
+		to = len(line)
+	}
+	for i := from; i < to; i++ {
+		line[i] = cell{}
+	}
+}
+
+func (t *terminal) write(s string) {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+
+		switch r {
+		case '\n':
+			t.row++
+			t.col = 0
+			t.line()
+		case '\r':
+			t.col = 0
+		case '\b':
+			if t.col > 0 {
+				t.col--
+			}
+		case '\t':
+			t.tab()
+		case '\a', '\f', '\v', 0:
+		case '\x1b':
+			i += t.escape(s[i:])
+		default:
+			t.put(r)
+		}
+	}
+}
+
+// escape() handles the escape sequence following an ESC at the start of
+// s, returning how many bytes of s it used.
+func (t *terminal) escape(s string) int {
+	if s == "" {
+		return 0
+	}
+
+	switch s[0] {
+	case '[':
+		return 1 + t.csi(s[1:])
+	case ']':
+		// operating system command, terminated by BEL or ST
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\a' {
+				return i + 1
+			}
+			if s[i] == '\x1b' && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return len(s)
+	case '7':
+		t.savedRow, t.savedCol = t.row, t.col
+		return 1
+	case '8':
+		t.row, t.col = t.savedRow, t.savedCol
+		return 1
+	case '(', ')', '*', '+', '#':
+		// character set selection takes one more byte
+		if len(s) > 1 {
+			return 2
+		}
+		return 1
+	default:
+		return 1
+	}
+}
+
+// csi() handles a control sequence, s being everything after "ESC [".
+func (t *terminal) csi(s string) int {
+	end := 0
+	for end < len(s) && (s[end] < 0x40 || s[end] > 0x7e) {
+		end++
+	}
+	if end == len(s) {
+		return len(s)
+	}
+
+	params, final := s[:end], s[end]
+	if strings.HasPrefix(params, "?") || strings.HasPrefix(params, ">") {
+		// private modes, e.g. hiding the cursor
+		return end + 1
+	}
+
+	args := strings.Split(params, ";")
+	arg := func(i, def int) int {
+		if i >= len(args) || args[i] == "" {
+			return def
+		}
+		n, err := strconv.Atoi(args[i])
+		if err != nil {
+			return def
+		}
+		return n
+	}
+	// count is a number of rows, columns or characters, where 0 means 1
+	// like a missing parameter, capped so a bogus count can't make the
+	// screen huge
+	count := func(i int) int {
+		n := arg(i, 1)
+		if n < 1 {
+			return 1
+		}
+		if n > maxCursorMove {
+			return maxCursorMove
+		}
+		return n
+	}
+
+	switch final {
+	case 'm':
+		t.attrs.set(params)
+		t.style = t.attrs.String()
+	case 'A':
+		t.row -= count(0)
+	case 'B', 'E':
+		t.row += count(0)
+		if final == 'E' {
+			t.col = 0
+		}
+	case 'F':
+		t.row -= count(0)
+		t.col = 0
+	case 'C':
+		t.col += count(0)
+	case 'D':
+		t.col -= count(0)
+	case 'G':
+		t.col = count(0) - 1
+	case 'H', 'f':
+		// positions are relative to the top of the output, since we
+		// don't know where it started on the screen
+		t.row = count(0) - 1
+		t.col = count(1) - 1
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			t.erase(t.col, len(t.line()))
+		case 1:
+			t.erase(0, t.col+1)
+		case 2:
+			t.erase(0, len(t.line()))
+		}
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			t.erase(t.col, len(t.line()))
+			t.lines = t.lines[:t.row+1]
+		case 1:
+			t.erase(0, t.col+1)
+			for i := 0; i < t.row; i++ {
+				t.lines[i] = nil
+			}
+		case 2, 3:
+			t.lines = nil
+			t.line()
+		}
+	case 'P':
+		line := t.line()
+		if t.col < len(line) {
+			n := count(0)
+			if t.col+n > len(line) {
+				n = len(line) - t.col
+			}
+			t.lines[t.row] = append(line[:t.col], line[t.col+n:]...)
+		}
+	case 'X':
+		t.erase(t.col, t.col+count(0))
+	case 's':
+		t.savedRow, t.savedCol = t.row, t.col
+	case 'u':
+		t.row, t.col = t.savedRow, t.savedCol
+	}
+
+	// the cursor can't leave the screen, which ends a screenful below
+	// the output so far
+	t.row = clamp(t.row, 0, len(t.lines)-1+defaultLines)
+	t.col = clamp(t.col, 0, maxCursorMove)
+	t.line()
+	return end + 1
+}
+
+// render() returns the terminal's contents, one line per row.
+func (t *terminal) render() string {
+	var b strings.Builder
+	for i, line := range t.lines {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+
+		end := len(line)
+		for end > 0 && line[end-1].r == 0 {
+			end--
+		}
+
+		style := ""
+		// lead is the cell a cell that's part of a wide character or
+		// tab belongs to
+		lead := -1
+		for j, c := range line[:end] {
+			if !c.right {
+				lead = j
+			}
+			if c.style != style {
+				if style != "" {
+					b.WriteString("\x1b[0m")
+				}
+				b.WriteString(c.style)
+				style = c.style
+			}
+			switch {
+			case c.right && lead >= 0 && (line[lead].r == '\t' || lead == j-1 && runeWidth(line[lead].r) == 2):
+			case c.r == 0:
+				b.WriteByte(' ')
+			default:
+				b.WriteRune(c.r)
+				b.WriteString(c.marks)
+			}
+		}
+		if style != "" {
+			b.WriteString("\x1b[0m")
+		}
+	}
+	return b.String()
+}
+
+// set() changes the attributes as the parameters of an SGR sequence
+// say.
+func (a *sgrAttrs) set(params string) {
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		code := codes[i]
+		n, err := strconv.Atoi(code)
+		switch {
+		case code == "" || err == nil && n == 0:
+			*a = sgrAttrs{}
+		// colors and underline styles given with colons, e.g. 38:5:208
+		case strings.HasPrefix(code, "38:"):
+			a.fg = code
+		case strings.HasPrefix(code, "48:"):
+			a.bg = code
+		case strings.HasPrefix(code, "4:"):
+			a.on[4] = code != "4:0"
+		case err != nil:
+		case n >= 1 && n <= 9:
+			a.on[n] = true
+		case n == 21:
+			a.on[4] = true
+		case n == 22:
+			a.on[1], a.on[2] = false, false
+		case n == 25:
+			a.on[5], a.on[6] = false, false
+		case n == 23 || n == 24 || n >= 27 && n <= 29:
+			a.on[n-20] = false
+		case n >= 30 && n <= 37 || n >= 90 && n <= 97:
+			a.fg = code
+		case n == 39:
+			a.fg = ""
+		case n >= 40 && n <= 47 || n >= 100 && n <= 107:
+			a.bg = code
+		case n == 49:
+			a.bg = ""
+		case n == 38 || n == 48:
+			// a 256 color takes 5 and its number, an RGB color 2 and
+			// three numbers
+			extra := 0
+			if i+1 < len(codes) && codes[i+1] == "5" {
+				extra = 2
+			} else if i+1 < len(codes) && codes[i+1] == "2" {
+				extra = 4
+			}
+			if extra == 0 || i+extra >= len(codes) {
+				return
+			}
+			color := strings.Join(codes[i:i+extra+1], ";")
+			if n == 38 {
+				a.fg = color
+			} else {
+				a.bg = color
+			}
+			i += extra
+		}
+	}
+}
+
+// String() returns the SGR sequence that sets the attributes, or "" if
+// they're the defaults.
+func (a sgrAttrs) String() string {
+	var codes []string
+	for n, on := range a.on {
+		if on {
+			codes = append(codes, strconv.Itoa(n))
+		}
+	}
+	if a.fg != "" {
+		codes = append(codes, a.fg)
+	}
+	if a.bg != "" {
+		codes = append(codes, a.bg)
+	}
+	if len(codes) == 0 {
+		return ""
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+// clamp() returns n limited to min..max.
+func clamp(n, min, max int) int {
+	if n > max {
+		n = max
+	}
+	if n < min {
+		n = min
+	}
+	return n
+}
@@ -0,0 +1,78 @@
+package readup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEmulateTerminal(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "hello\nworld", "hello\nworld"},
+		{"carriage return overwrites", "abc\rx", "xbc"},
+		{"progress redraw", "10%\r100%\n", "100%\n"},
+		{"backspace", "ab\bc", "ac"},
+		{"erase to end of line", "abcdef\r\x1b[Kxy", "xy"},
+		{"cursor up", "one\ntwo\x1b[1A\rON", "ONe\ntwo"},
+		{"home", "abc\x1b[Hx", "xbc"},
+		{"home with zero parameters", "abc\x1b[0;0Hx", "xbc"},
+		{"zero movement moves one", "abc\x1b[0Dx", "abx"},
+		{"cursor column", "abcdef\x1b[3Gx", "abxdef"},
+		{"move before the start", "ab\x1b[99Dx", "xb"},
+		{"move above the top", "ab\x1b[99Ax", "abx"},
+		{"negative count", "abcdef\r\x1b[-3Px", "xcdef"},
+		{"delete characters", "abcdef\r\x1b[2P", "cdef"},
+		{"erase characters", "abcdef\r\x1b[2X", "  cdef"},
+		{"clear screen", "abc\ndef\x1b[H\x1b[2Jx", "x"},
+		{"private mode dropped", "\x1b[?25lab\x1b[?25h", "ab"},
+		{"colors kept", "\x1b[31mred\x1b[0m", "\x1b[31mred\x1b[0m"},
+		{"colors set again", "\x1b[31ma\x1b[31mb\x1b[31mc", "\x1b[31mabc\x1b[0m"},
+		{"reset and set together", "\x1b[31ma\x1b[0;1mb", "\x1b[31ma\x1b[0m\x1b[1mb\x1b[0m"},
+		{"attributes turned off", "\x1b[1;31;42ma\x1b[39mb\x1b[49mc\x1b[22md", "\x1b[1;31;42ma\x1b[0m\x1b[1;42mb\x1b[0m\x1b[1mc\x1b[0md"},
+		{"256 and rgb colors", "\x1b[38;5;208;48;2;1;2;3ma", "\x1b[38;5;208;48;2;1;2;3ma\x1b[0m"},
+		{"tab kept", "a\tb", "a\tb"},
+		{"tab stops", "a\tb\r\x1b[8Cx\tabcdefgh\ty", "a\tx\tabcdefgh\ty"},
+		{"tab over text", "abcdefghij\r\tx", "abcdefghxj"},
+		{"overwriting a tab", "a\tb\r\x1b[3Cx", "a  x    b"},
+		{"osc dropped", "\x1b]0;title\x07ab", "ab"},
+		{"wide characters", "漢字\r\x1b[2Cx", "漢x"},
+		{"overwriting half a wide character", "漢\rx", "x"},
+		{"combining mark", "éx", "éx"},
+		{"unfinished sequence", "ab\x1b[", "ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := emulateTerminal(tt.in); got != tt.want {
+				t.Errorf("emulateTerminal(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmulateTerminalHugeMoves(t *testing.T) {
+	for _, in := range []string{
+		"a\x1b[99999999B",
+		"a\x1b[99999999E",
+		"a\x1b[99999999;99999999H",
+		"a\x1b[99999999C",
+		"a\x1b[99999999G",
+		"a\x1b[99999999X",
+		strings.Repeat("\x1b[99999999B", 1000),
+	} {
+		t.Run(strings.ReplaceAll(in[:12], "\x1b", "ESC"), func(t *testing.T) {
+			term := &terminal{}
+			term.write(in)
+			if len(term.lines) > 1000*(defaultLines+1) {
+				t.Errorf("%d lines after %q", len(term.lines), in)
+			}
+			for _, line := range term.lines {
+				if len(line) > maxCursorMove+1 {
+					t.Errorf("line %d cells wide after %q", len(line), in)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package readup
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A block can pin the version of the tool it documents with e.g.
+// `tool-version=mycli>=1.4`. Before running the block, readup runs
+// `mycli --version` and compares the first version number in its output
+// against the constraint. The tool is run the way the block's command
+// is, in the block's directory and environment and under its runner, so
+// the version checked is that of the mycli the block would run, e.g.
+// the one --path-prepend or a venv puts first in PATH.
+
+// toolConstraint is a parsed tool-version entry.
+type toolConstraint struct {
+	tool    string
+	op      string
+	version string
+}
+
+var (
+	toolConstraintPattern = regexp.MustCompile(`^([\w.+/\\:-]+)(>=|<=|==|!=|=|>|<)(.+)$`)
+	versionPattern        = regexp.MustCompile(`\d+(?:\.\d+)*`)
+)
+
+func parseToolConstraint(s string) (*toolConstraint, error) {
+	match := toolConstraintPattern.FindStringSubmatch(s)
+	if match == nil || !versionPattern.MatchString(match[3]) {
+		return nil, fmt.Errorf("invalid tool-version %q, expected e.g. mycli>=1.4", s)
+	}
+	return &toolConstraint{tool: match[1], op: match[2], version: match[3]}, nil
+}
+
+func (c *toolConstraint) String() string {
+	return c.tool + c.op + c.version
+}
+
+// satisfiedBy() reports whether version meets the constraint. Equality
+// only compares as many components as the constraint has, so "=1.4"
+// accepts 1.4.2.
+func (c *toolConstraint) satisfiedBy(version string) bool {
+	cmp := compareVersions(version, c.version)
+	switch c.op {
+	case "=", "==":
+		return versionHasPrefix(version, c.version)
+	case "!=":
+		return !versionHasPrefix(version, c.version)
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp < 0
+	}
+}
+
+func versionParts(version string) []int {
+	var parts []int
+	for _, s := range strings.Split(versionPattern.FindString(version), ".") {
+		n, _ := strconv.Atoi(s)
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// compareVersions() compares dotted version numbers, treating missing
+// components as zero.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionHasPrefix(version, prefix string) bool {
+	pv, pp := versionParts(version), versionParts(prefix)
+	if len(pv) < len(pp) {
+		return false
+	}
+	for i := range pp {
+		if pv[i] != pp[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// toolVersion() runs `tool --version` the way eo runs a block's command,
+// and returns the first version number in its output, caching the
+// result for the rest of the run for blocks run the same way.
+func toolVersion(tool string, eo execOptions, opts *options) (string, error) {
+	key := strings.Join(append(append([]string{tool, eo.dir, eo.shell}, eo.runner...), eo.env...), "\x00")
+	if version, ok := opts.toolVersions[key]; ok {
+		return version, nil
+	}
+
+	eo.print = false
+	eo.capture = "pipe"
+	out, exitCode, err := execCommand(tool+" --version", eo)
+	if err == nil && exitCode != 0 {
+		err = fmt.Errorf("exited with status %d: %s", exitCode, strings.TrimSpace(out))
+	}
+	if err != nil {
+		return "", fmt.Errorf("checking version of %s: %w", tool, err)
+	}
+
+	version := versionPattern.FindString(out)
+	if version == "" {
+		return "", fmt.Errorf("checking version of %s: no version number in %q", tool, strings.TrimSpace(out))
+	}
+
+	if opts.toolVersions == nil {
+		opts.toolVersions = map[string]string{}
+	}
+	opts.toolVersions[key] = version
+	return version, nil
+}
+
+// checkToolVersions() checks a block's tool-version constraints, with
+// the tools run the way eo runs the block, and returns the tool versions
+// found, e.g. "mycli 1.4.2". A mismatch is printed as a warning, or
+// returned as an error if strictToolVersions is set.
+func checkToolVersions(command string, attrs blockAttrs, eo execOptions, opts *options) ([]string, error) {
+	var found []string
+	for _, s := range attrs.list("tool-version") {
+		constraint, err := parseToolConstraint(s)
+		if err != nil {
+			return nil, err
+		}
+
+		version, err := toolVersion(constraint.tool, eo, opts)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, constraint.tool+" "+version)
+
+		if constraint.satisfiedBy(version) {
+			continue
+		}
+
+		msg := fmt.Sprintf("command %q expects %s, found %s %s", command, constraint, constraint.tool, version)
+		if opts.strictToolVersions {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", msg)
+	}
+	return found, nil
+}
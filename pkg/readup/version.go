@@ -0,0 +1,61 @@
+package readup
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// version, commit and buildDate are set at build time with e.g.
+//
+//	go build -ldflags "-X github.com/bakks/readup/pkg/readup.version=1.2.0 -X github.com/bakks/readup/pkg/readup.commit=$(git rev-parse HEAD)"
+//
+// Anything not set is filled in by buildInfo() from what the Go
+// toolchain records, where it can.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// buildInfo() returns the version, commit and build date of this
+// binary.
+func buildInfo() (string, string, string) {
+	v, c, d := version, commit, buildDate
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v, c, d
+	}
+
+	// set by go install module@version
+	if v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		v = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if c == "" {
+				c = setting.Value
+			}
+		case "vcs.time":
+			if d == "" {
+				d = setting.Value
+			}
+		}
+	}
+	return v, c, d
+}
+
+func printVersion() {
+	v, c, d := buildInfo()
+	if c == "" {
+		c = "unknown"
+	}
+	if d == "" {
+		d = "unknown"
+	}
+	fmt.Printf("readup %s\n", v)
+	fmt.Printf("commit:     %s\n", c)
+	fmt.Printf("built:      %s\n", d)
+	fmt.Printf("go:         %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
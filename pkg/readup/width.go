@@ -0,0 +1,43 @@
+package readup
+
+import (
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Output is laid out by the columns it takes up on a terminal rather
+// than by bytes or runes: wide East Asian characters and most emoji take
+// two columns, and combining marks, like the accent in a decomposed "é",
+// and other zero-width characters take none, by Unicode's East Asian
+// Width property, which is what terminals use.
+
+// widths measures characters. Those of ambiguous width are taken to be
+// narrow whatever the locale, as most terminals show them, so output is
+// laid out the same wherever readup runs.
+var widths = func() *runewidth.Condition {
+	c := runewidth.NewCondition()
+	c.EastAsianWidth = false
+	return c
+}()
+
+// runeWidth() returns how many columns r takes up on a terminal.
+func runeWidth(r rune) int {
+	return widths.RuneWidth(r)
+}
+
+// displayWidth() returns how many columns s takes up on a terminal.
+// Escape sequences don't count towards the width.
+func displayWidth(s string) int {
+	width := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			i += escapeLength(s[i:])
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		width += runeWidth(r)
+		i += size
+	}
+	return width
+}
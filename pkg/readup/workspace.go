@@ -0,0 +1,568 @@
+package readup
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A workspace is a tree of documents, like the READMEs of a monorepo's
+// packages, sharing the config at its root. readup looks for the config
+// in the current directory and then each of its parents, so the same
+// config applies wherever in the tree it runs, and `readup --all` runs
+// every document the config's workspace section lists:
+//
+//	workspace:
+//	  files: [README.md, "packages/*/README.md", "docs/**/*.md"]
+//	  exclude: ["examples/**"]
+//	  store_dir: .readup
+//
+// Globs are relative to the root, and ** matches any number of
+// directories. Without files every Markdown document in the tree is
+// run, except those matching exclude. Given a directory rather than a
+// file, readup runs every Markdown document under it the same way, with
+// globs relative to that directory. --include and --exclude narrow
+// either further, and files and directories .gitignore ignores are
+// skipped, like build output and vendored trees, unless --no-gitignore
+// is given.
+//
+// Each document's blocks run in the document's own directory, and with
+// --jobs or the workspace's jobs several documents run at once, though a
+// document's own blocks still run one after another. With
+// store_dir every document's output store is kept under that directory
+// at the root rather than beside the document, so one cache holds the
+// whole workspace's recorded output. --timings, --notify and the config's
+// notify and deliver sections apply to each document as they would to a
+// run of it alone.
+
+// workspaceConfig is the workspace section of the config.
+type workspaceConfig struct {
+	Files    []string `yaml:"files"`
+	Exclude  []string `yaml:"exclude"`
+	StoreDir string   `yaml:"store_dir"`
+	// Jobs is how many documents run at once, 0 for one per CPU
+	Jobs *int `yaml:"jobs"`
+}
+
+// documentFilter chooses which files are run, by their slash-separated
+// paths relative to the directory being searched.
+type documentFilter struct {
+	// files are the documents, include narrows them if it's not empty,
+	// and exclude leaves some out
+	files, include, exclude []string
+	// ignored are the files, and directories ending in a slash, to skip
+	// whatever the globs say, see gitIgnored()
+	ignored map[string]bool
+}
+
+func (f documentFilter) match(name string) bool {
+	return !f.ignored[name] && matchAny(f.files, name) &&
+		(len(f.include) == 0 || matchAny(f.include, name)) &&
+		!matchAny(f.exclude, name)
+}
+
+// defaultDocuments matches the documents in a workspace or directory
+// when the config doesn't list them.
+var defaultDocuments = []string{"**/*.md"}
+
+// multiFileModes are the commands that can run many documents at once.
+var multiFileModes = []string{"run", "check", "test", "diff"}
+
+// singleFileFlags are the flags that only make sense for one document.
+var singleFileFlags = []string{"patch", "output", "diff-output", "diff-base", "since", "store", "github-pr", "metrics", "keep-temp", "pick"}
+
+// checkMultiFile() returns an error if readup can't run mode on many
+// documents with the flags it was given.
+func checkMultiFile(mode string) error {
+	if !contains(multiFileModes, mode) {
+		return fmt.Errorf("%s only works on one file, not with --all or a directory", mode)
+	}
+	var set []string
+	flag.Visit(func(f *flag.Flag) {
+		if contains(singleFileFlags, f.Name) {
+			set = append(set, "--"+f.Name)
+		}
+	})
+	if len(set) == 1 {
+		return fmt.Errorf("%s only works on one file, not with --all or a directory", set[0])
+	}
+	if len(set) > 1 {
+		return fmt.Errorf("%s only work on one file, not with --all or a directory", strings.Join(set, ", "))
+	}
+	return nil
+}
+
+// workspaceFiles() returns the documents in cfg's workspace, or under
+// dir if it's not empty, narrowed by the include and exclude globs, and
+// leaving out those git ignores if gitignore is set.
+func workspaceFiles(cfg *config, dir string, include, exclude []string, gitignore bool) ([]string, error) {
+	filter := documentFilter{
+		files:   defaultDocuments,
+		include: include,
+		exclude: append(exclude, cfg.Workspace.Exclude...),
+	}
+	root := dir
+	if root == "" {
+		root = firstString(cfg.dir, ".")
+		if len(cfg.Workspace.Files) > 0 {
+			filter.files = cfg.Workspace.Files
+		}
+	}
+	if gitignore {
+		filter.ignored = gitIgnored(root)
+	}
+	return findDocuments(root, filter)
+}
+
+// findDocuments() returns the files under root that filter matches,
+// sorted, and relative to the current directory. Hidden directories like
+// .git are skipped.
+func findDocuments(root string, filter documentFilter) ([]string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	err = filepath.WalkDir(root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if name != root && (strings.HasPrefix(entry.Name(), ".") || filter.ignored[filepath.ToSlash(rel)+"/"]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !filter.match(filepath.ToSlash(rel)) {
+			return nil
+		}
+		if abs, err := filepath.Abs(name); err == nil {
+			if rel, err := filepath.Rel(cwd, abs); err == nil {
+				name = rel
+			}
+		}
+		files = append(files, name)
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return comparePaths(files[i], files[j]) < 0
+	})
+	return files, err
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob() reports whether the slash-separated path name matches
+// pattern, where ** matches any number of directories and the rest is
+// as for path.Match().
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], name[1:])
+}
+
+// storePath() returns the output store for filename, which is beside it
+// unless the workspace has a store_dir.
+func (c *config) storePath(filename string) string {
+	if c.Workspace.StoreDir == "" || c.dir == "" {
+		return storePath(filename)
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return storePath(filename)
+	}
+	rel, err := filepath.Rel(c.dir, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return storePath(filename)
+	}
+	return storePath(filepath.Join(c.path(c.Workspace.StoreDir), rel))
+}
+
+// fileRun is the outcome of running one of many documents.
+type fileRun struct {
+	filename string
+	original string
+	content  string
+	results  []*blockResult
+	// failures are the blocks that failed with applySuccessful
+	failures blockErrors
+	diff     string
+}
+
+func (r *fileRun) changed() bool {
+	return r.content != r.original
+}
+
+// multiRun is how to run many documents.
+type multiRun struct {
+	// mode is one of multiFileModes
+	mode string
+	// jobs is how many documents run at once
+	jobs int
+	// diffStyle is how changes are shown, one of diffStyles
+	diffStyle string
+	// report, if set, is where to write a report in reportFormat on
+	// every document's blocks
+	report, reportFormat string
+	// timings lists how long each document's blocks took
+	timings bool
+	// commit, if set, is the message to commit the updated documents
+	// with, all in one commit
+	commit string
+	// notify and deliver are sent about each document as they would be
+	// about a run of it alone
+	notify  notifyConfig
+	deliver deliverConfig
+	// flags are the options from readup's flags, and opts those for the
+	// current directory
+	flags Options
+	opts  *options
+}
+
+// runFiles() runs each of files the way m says, and returns readup's
+// exit status. When more than one document runs at once their commands
+// aren't printed as they run, only a line for each document as it
+// finishes. Whatever order they finish in, they're reported in order,
+// and a document failing doesn't stop the others: every failure is
+// listed at the end.
+func runFiles(files []string, m multiRun) int {
+	runs := make([]*fileRun, len(files))
+	errs := make([]error, len(files))
+	parallel(len(files), m.jobs, func(i int) {
+		start := time.Now()
+		runs[i], errs[i] = runFile(m.mode, files[i], m.jobs > 1, m.flags, m.opts)
+		elapsed := time.Since(start)
+		if m.jobs > 1 {
+			status := paint(colors.success, "done  ")
+			if errs[i] != nil {
+				status = paint(colors.failure, "FAILED")
+			}
+			fmt.Printf("%s %s (%s)\n", status, files[i], elapsed.Round(100*time.Millisecond))
+		}
+		var results []*blockResult
+		if runs[i] != nil {
+			results = runs[i].results
+		}
+		if err := notifyRun(m.notify, files[i], results, errs[i], elapsed); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: couldn't send notification: %s\n", err.Error())
+		}
+	})
+
+	// failures are reported at the end, and the documents that didn't
+	// fail are reported and updated as usual, as are those that only
+	// partly failed with applySuccessful
+	var ok []*fileRun
+	var failed []string
+	for i, err := range errs {
+		if runs[i] != nil {
+			ok = append(ok, runs[i])
+		}
+		if err == nil {
+			continue
+		}
+		var failures blockErrors
+		if !errors.As(err, &failures) {
+			failures = blockErrors{err}
+		}
+		for _, failure := range failures {
+			logEvent("error", map[string]interface{}{"file": files[i], "error": failure.Error()})
+			msg := failure.Error()
+			if !strings.HasPrefix(msg, files[i]+":") {
+				msg = files[i] + ": " + msg
+			}
+			failed = append(failed, msg)
+		}
+	}
+
+	status := runSucceeded(ok, len(files), m)
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "\n%d failures:\n", len(failed))
+		for _, msg := range failed {
+			fmt.Fprintf(os.Stderr, "  %s\n", msg)
+		}
+		return 1
+	}
+	return status
+}
+
+// runSucceeded() reports on, or updates, the documents in runs that ran
+// without failing, out of total, and returns readup's exit status.
+func runSucceeded(runs []*fileRun, total int, m multiRun) int {
+	blocks, stale := 0, 0
+	var results []fileResults
+	for _, run := range runs {
+		blocks += len(run.results)
+		results = append(results, fileResults{filename: run.filename, results: run.results, failures: run.failures})
+	}
+	if m.report != "" {
+		if err := writeReport(m.report, m.reportFormat, results); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			return 1
+		}
+	}
+
+	var changed []*fileRun
+	for _, run := range runs {
+		if m.timings {
+			printTimings(fmt.Sprintf("Timings for %s:", run.filename), run.results)
+		}
+		if err := deliverChanges(m.deliver, run.filename, run.results, run.diff); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			return 1
+		}
+		switch m.mode {
+		case "test":
+			stale += printTestResults(run.filename, run.results)
+		case "check":
+			logEvent("check", map[string]interface{}{"file": run.filename, "up_to_date": !run.changed()})
+			if run.changed() {
+				fmt.Printf("%s %s\n", run.filename, paint(colors.failure, "is out of date"))
+			}
+		default:
+			if run.diff == "" {
+				break
+			}
+			if err := printDiff(m.diffStyle, run.filename, run.original, run.diff, run.results); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+				return 1
+			}
+		}
+		if run.changed() {
+			changed = append(changed, run)
+		}
+	}
+
+	switch m.mode {
+	case "test":
+		if stale > 0 {
+			fmt.Printf("%d of %d blocks in %d files out of date\n", stale, blocks, len(runs))
+			return 1
+		}
+		fmt.Printf("%d blocks in %d files ok\n", blocks, len(runs))
+		return 0
+	case "check":
+		if len(changed) > 0 {
+			fmt.Printf("%d of %d files are out of date, run readup to update them\n", len(changed), total)
+			return 1
+		}
+		fmt.Printf("%d of %d files are up to date\n", len(runs), total)
+		return 0
+	case "diff":
+		return 0
+	}
+
+	if len(changed) == 0 {
+		fmt.Printf("%d of %d files are up to date\n", len(runs), total)
+		return 0
+	}
+	if !confirm(fmt.Sprintf("Update %d files?", len(changed))) {
+		return 0
+	}
+	// every file is merged before any is written, so a conflict in one
+	// doesn't leave the others updated
+	var names, contents []string
+	for _, run := range changed {
+		content, err := mergeChanges(run.filename, run.original, run.content)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			return 1
+		}
+		names = append(names, run.filename)
+		contents = append(contents, content)
+	}
+	if err := writeFiles(names, contents); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 1
+	}
+	for _, name := range names {
+		logEvent("write", map[string]interface{}{"file": name})
+		fmt.Printf("Updated %s\n", name)
+	}
+	if m.commit != "" {
+		if err := gitCommit(names, m.commit); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			return 1
+		}
+		fmt.Printf("Committed %d files\n", len(names))
+	}
+	return 0
+}
+
+// writeFiles() writes contents to the files names, all or nothing: if
+// one can't be written, or readup is interrupted part way through, the
+// files already written are put back as they were.
+func writeFiles(names, contents []string) error {
+	defer func() {
+		interrupts.Lock()
+		interrupts.restore = nil
+		interrupts.Unlock()
+	}()
+
+	previous := map[string]string{}
+	for i, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return restoreFiles(previous, err)
+		}
+		interrupts.Lock()
+		previous[name] = string(data)
+		interrupts.restore = previous
+		interrupts.Unlock()
+
+		if err := writeFile(name, contents[i]); err != nil {
+			return restoreFiles(previous, err)
+		}
+	}
+	return nil
+}
+
+// restoreFiles() writes back the previous contents of files after err
+// stopped writeFiles(), and returns err saying so.
+func restoreFiles(previous map[string]string, err error) error {
+	interrupts.Lock()
+	defer interrupts.Unlock()
+	for name, content := range previous {
+		if restoreErr := os.WriteFile(name, []byte(content), 0644); restoreErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: couldn't restore %s: %s\n", name, restoreErr.Error())
+		}
+	}
+	if len(previous) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w, so no files were updated", err)
+}
+
+// parallel() calls f with each of 0 to n-1, up to jobs at a time, and
+// in order if jobs is 1.
+func parallel(n, jobs int, f func(i int)) {
+	if jobs <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, jobs)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// runFile() runs the blocks of one of many documents, in the document's
+// directory and with its directory's config, and quietly if quiet is
+// set. A failure is returned for the document alone, without affecting
+// the others.
+func runFile(mode, filename string, quiet bool, o Options, opts *options) (*fileRun, error) {
+	if mode == "run" {
+		if err := checkWritable(filename); err != nil {
+			return nil, err
+		}
+		if err := lockFile(filename); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg, err := loadConfigIn(o.Config, filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+	fileOpts, err := newOptions(cfg, o)
+	if err != nil {
+		return nil, err
+	}
+	fileOpts.dir = filepath.Dir(filename)
+	fileOpts.quiet = quiet
+	if opts.offline != nil {
+		fileOpts.offlineAll = cfg.OfflineScope == "all"
+		fileOpts.offline, err = loadOutputStoreIfExists(cfg.storePath(filename))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	// with applySuccessful a document whose blocks failed is returned
+	// too, with the failures
+	content, results, runErr := runDocument(filename, data, fileOpts)
+	var failures blockErrors
+	if runErr != nil && !errors.As(runErr, &failures) {
+		return nil, runErr
+	}
+
+	run := &fileRun{filename: filename, original: string(data), content: content, results: results, failures: failures}
+	if run.changed() {
+		run.diff, err = contentDiff(filename, run.original, run.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return run, runErr
+}
+
+// jobs() returns how many documents to run at once, given the --jobs
+// flag, which is negative if it wasn't given, and the config.
+func jobs(flagJobs int, cfg *config) int {
+	n := flagJobs
+	if n < 0 {
+		n = 1
+		if cfg.Workspace.Jobs != nil {
+			n = *cfg.Workspace.Jobs
+		}
+	}
+	if n == 0 {
+		n = runtime.NumCPU()
+	}
+	return n
+}